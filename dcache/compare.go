@@ -0,0 +1,349 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// The compare subcommand runs the same churn workload under several
+// strategies for avoiding slow rmdirs and prints a table comparing them.
+//
+// The workload creates -files files in sequence, removing each one -live
+// files later, so that the working directory always holds a small number of
+// live files. Every -sample-every files it times a (failing) rmdir of the
+// directory holding the newest file plus a stat of a live file and of a
+// missing name.
+
+var strategies = map[string]func() strategy{
+	// Do nothing.
+	"baseline": func() strategy { return &flatStrategy{} },
+	// Every -recreate-every files, make a new directory, move the live
+	// files into it, and rmdir the old one.
+	"recreate": func() strategy { return &recreateStrategy{} },
+	// Spread files over -subdirs hashed subdirectories.
+	"hashed": func() strategy { return &hashedStrategy{} },
+	// Reuse a small pool of file names.
+	"reuse": func() strategy { return &flatStrategy{reuse: true} },
+	// Like recreate, but instead of removing the old directory
+	// synchronously, rename it into a trash directory and delete it in the
+	// background.
+	"rename": func() strategy { return &recreateStrategy{background: true} },
+}
+
+var strategyOrder = []string{"baseline", "recreate", "hashed", "reuse", "rename"}
+
+type compareConfig struct {
+	files         int
+	live          int
+	sampleEvery   int
+	recreateEvery int
+	subdirs       int
+}
+
+// A strategy controls where the workload's files live.
+type strategy interface {
+	// init prepares the strategy to use base (an empty directory).
+	init(base string, cfg *compareConfig) error
+	// path gives the name of the ith file.
+	path(i int) string
+	// maintain is called after file i has been created. Files lo through
+	// hi (inclusive) are live. It reports whether it did any work.
+	maintain(i, lo, hi int) (bool, error)
+	// close waits for any background work.
+	close() error
+}
+
+type flatStrategy struct {
+	d     string
+	reuse bool
+	pool  int
+}
+
+func (s *flatStrategy) init(base string, cfg *compareConfig) error {
+	s.d = base
+	s.pool = 2 * cfg.live
+	return nil
+}
+
+func (s *flatStrategy) path(i int) string {
+	if s.reuse {
+		i %= s.pool
+	}
+	return filepath.Join(s.d, strconv.Itoa(i))
+}
+
+func (s *flatStrategy) maintain(int, int, int) (bool, error) { return false, nil }
+func (s *flatStrategy) close() error                         { return nil }
+
+type hashedStrategy struct {
+	subdirs []string
+}
+
+func (s *hashedStrategy) init(base string, cfg *compareConfig) error {
+	for i := range cfg.subdirs {
+		sub := filepath.Join(base, fmt.Sprintf("%02x", i))
+		if err := os.Mkdir(sub, 0o755); err != nil {
+			return err
+		}
+		s.subdirs = append(s.subdirs, sub)
+	}
+	return nil
+}
+
+func (s *hashedStrategy) path(i int) string {
+	name := strconv.Itoa(i)
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	return filepath.Join(s.subdirs[h.Sum32()%uint32(len(s.subdirs))], name)
+}
+
+func (s *hashedStrategy) maintain(int, int, int) (bool, error) { return false, nil }
+func (s *hashedStrategy) close() error                         { return nil }
+
+type recreateStrategy struct {
+	background bool
+
+	base  string
+	every int
+	gen   int
+	d     string
+	trash string
+	wg    sync.WaitGroup
+	errMu sync.Mutex
+	err   error // from background removal
+}
+
+func (s *recreateStrategy) init(base string, cfg *compareConfig) error {
+	s.base = base
+	s.every = cfg.recreateEvery
+	s.trash = filepath.Join(base, "trash")
+	if err := os.Mkdir(s.trash, 0o755); err != nil {
+		return err
+	}
+	s.d = s.genDir()
+	return os.Mkdir(s.d, 0o755)
+}
+
+func (s *recreateStrategy) genDir() string {
+	return filepath.Join(s.base, "gen"+strconv.Itoa(s.gen))
+}
+
+func (s *recreateStrategy) path(i int) string {
+	return filepath.Join(s.d, strconv.Itoa(i))
+}
+
+func (s *recreateStrategy) maintain(i, lo, hi int) (bool, error) {
+	if i == 0 || i%s.every != 0 {
+		return false, nil
+	}
+	old := s.d
+	s.gen++
+	s.d = s.genDir()
+	if err := os.Mkdir(s.d, 0o755); err != nil {
+		return true, err
+	}
+	for j := lo; j <= hi; j++ {
+		name := strconv.Itoa(j)
+		if err := os.Rename(filepath.Join(old, name), filepath.Join(s.d, name)); err != nil {
+			return true, err
+		}
+	}
+	if !s.background {
+		return true, os.Remove(old)
+	}
+	dead := filepath.Join(s.trash, filepath.Base(old))
+	if err := os.Rename(old, dead); err != nil {
+		return true, err
+	}
+	s.wg.Go(func() {
+		if err := os.Remove(dead); err != nil {
+			s.errMu.Lock()
+			s.err = errors.Join(s.err, err)
+			s.errMu.Unlock()
+		}
+	})
+	return true, nil
+}
+
+func (s *recreateStrategy) close() error {
+	s.wg.Wait()
+	return s.err
+}
+
+type compareResult struct {
+	name   string
+	rmdir  []time.Duration
+	lookup []time.Duration
+	maint  []time.Duration
+	total  time.Duration
+}
+
+func runStrategy(base, name string, cfg *compareConfig) (*compareResult, error) {
+	dir, err := os.MkdirTemp(base, name)
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	s := strategies[name]()
+	if err := s.init(dir, cfg); err != nil {
+		return nil, err
+	}
+	r := &compareResult{name: name}
+	start := time.Now()
+	for i := range cfg.files {
+		if err := touch(s.path(i)); err != nil {
+			return nil, err
+		}
+		lo := max(i-cfg.live+1, 0)
+		if lo > 0 {
+			if err := os.Remove(s.path(lo - 1)); err != nil {
+				return nil, err
+			}
+		}
+		mstart := time.Now()
+		did, err := s.maintain(i, lo, i)
+		if err != nil {
+			return nil, err
+		}
+		if did {
+			r.maint = append(r.maint, time.Since(mstart))
+		}
+		if i%cfg.sampleEvery != 0 {
+			continue
+		}
+		// Time the rmdir of the directory that holds the newest file,
+		// which is the one that has taken the churn (for hashed, one of
+		// the subdirectories).
+		p := s.path(i)
+		d, err := timeRmdir(filepath.Dir(p))
+		if err != nil {
+			return nil, err
+		}
+		r.rmdir = append(r.rmdir, d)
+		for _, name := range []string{
+			p,
+			filepath.Join(filepath.Dir(p), "missing"+strconv.Itoa(i)),
+		} {
+			d, err := timeStat(name)
+			if err != nil {
+				return nil, err
+			}
+			r.lookup = append(r.lookup, d)
+		}
+	}
+	if err := s.close(); err != nil {
+		return nil, err
+	}
+	r.total = time.Since(start)
+	return r, nil
+}
+
+func timeStat(name string) (time.Duration, error) {
+	start := time.Now()
+	_, err := os.Stat(name)
+	elapsed := time.Since(start)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return 0, err
+	}
+	return elapsed, nil
+}
+
+func compare(args []string) {
+	flags := flag.NewFlagSet("compare", flag.ExitOnError)
+	var (
+		baseDir = flags.String("dir", os.TempDir(), "base directory in which to create temp dirs")
+		names   = flags.String("strategies", strings.Join(strategyOrder, ","), "comma-separated strategies to compare")
+		cfg     compareConfig
+	)
+	flags.IntVar(&cfg.files, "files", 200_000, "total number of files to create and remove")
+	flags.IntVar(&cfg.live, "live", 100, "number of live files at any time")
+	flags.IntVar(&cfg.sampleEvery, "sample-every", 500, "take latency samples every this many files")
+	flags.IntVar(&cfg.recreateEvery, "recreate-every", 20_000, "recreate the directory every this many files (recreate, rename)")
+	flags.IntVar(&cfg.subdirs, "subdirs", 256, "number of subdirectories (hashed)")
+	flags.Parse(args)
+	if flags.NArg() > 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	if cfg.files < 1 || cfg.live < 1 || cfg.sampleEvery < 1 || cfg.recreateEvery < 1 || cfg.subdirs < 1 {
+		log.Fatal("-files, -live, -sample-every, -recreate-every, and -subdirs must be positive")
+	}
+
+	var selected []string
+	for name := range strings.SplitSeq(*names, ",") {
+		name = strings.TrimSpace(name)
+		if _, ok := strategies[name]; !ok {
+			log.Fatalf("Unknown strategy %q", name)
+		}
+		selected = append(selected, name)
+	}
+
+	tmp, err := os.MkdirTemp(*baseDir, "dcache")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	log.Println("Using temp dir", tmp)
+
+	var results []*compareResult
+	for _, name := range selected {
+		log.Printf("Running %s...", name)
+		r, err := runStrategy(tmp, name, &cfg)
+		if err != nil {
+			log.Fatalf("Strategy %s failed: %s", name, err)
+		}
+		results = append(results, r)
+	}
+	printComparison(os.Stdout, results)
+}
+
+func printComparison(w io.Writer, results []*compareResult) {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "strategy\trmdir p50\tp90\tp99\tmax\tlookup p50\tp90\tp99\tmax\tmaint max\ttotal\t")
+	for _, r := range results {
+		row := []string{r.name}
+		for _, ds := range [][]time.Duration{r.rmdir, r.lookup} {
+			for _, q := range []float64{0.5, 0.9, 0.99, 1} {
+				row = append(row, formatDuration(percentile(ds, q)))
+			}
+		}
+		row = append(row, formatDuration(percentile(r.maint, 1)), r.total.Round(time.Millisecond).String())
+		fmt.Fprintln(tw, strings.Join(row, "\t")+"\t")
+	}
+	tw.Flush()
+}
+
+// percentile returns the qth quantile of ds (0 <= q <= 1) or 0 if ds is empty.
+func percentile(ds []time.Duration, q float64) time.Duration {
+	if len(ds) == 0 {
+		return 0
+	}
+	ds = slices.Clone(ds)
+	slices.Sort(ds)
+	return ds[int(q*float64(len(ds)-1))]
+}
+
+func formatDuration(d time.Duration) string {
+	switch {
+	case d >= time.Millisecond:
+		return d.Round(10 * time.Microsecond).String()
+	case d >= time.Microsecond:
+		return d.Round(100 * time.Nanosecond).String()
+	default:
+		return d.String()
+	}
+}
@@ -1,56 +1,228 @@
+// Dcache measures how rmdir of a non-empty directory slows down as the
+// directory accumulates negative dentries from file churn.
+//
+// For each file count in -counts (repeated -reps times), it creates a fresh
+// directory containing a single file, creates and removes that many other
+// files in it using the -op operation, and then times an rmdir (which fails
+// with ENOTEMPTY). Each measurement is written as a CSV or JSON line along with
+// the dentry-state and dentry slab stats at that point.
+//
+// The compare subcommand (dcache compare [flags]) instead runs a churn
+// workload under several mitigation strategies and prints a table comparing
+// their rmdir and lookup latencies.
+//
+// Everything happens under a temp dir; no root is needed.
 package main
 
 import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
 	"log"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 
 	"golang.org/x/sys/unix"
 )
 
-// x
-
 func main() {
 	log.SetFlags(0)
-	baseDir := os.TempDir()
-	if len(os.Args) > 1 {
-		baseDir = os.Args[1]
+	if len(os.Args) > 1 && os.Args[1] == "compare" {
+		compare(os.Args[2:])
+		return
+	}
+	var (
+		baseDir = flag.String("dir", os.TempDir(), "base directory in which to create temp dirs")
+		counts  = flag.String("counts", "0,1000,10000,50000,100000", "comma-separated file counts to sweep")
+		reps    = flag.Int("reps", 3, "repetitions per file count")
+		opName  = flag.String("op", "unlink", `create/remove operation ("unlink", "rename", or "tmpfile")`)
+		format  = flag.String("format", "csv", `output format ("csv" or "json")`)
+	)
+	flag.Parse()
+	if flag.NArg() > 0 {
+		flag.Usage()
+		os.Exit(2)
 	}
-	log.Println("Using base dir", baseDir)
 
-	tmp, err := os.MkdirTemp(baseDir, "")
+	op, ok := churnOps[*opName]
+	if !ok {
+		log.Fatalf("Unknown -op %q", *opName)
+	}
+	ns, err := parseCounts(*counts)
+	if err != nil {
+		log.Fatalln("Bad -counts:", err)
+	}
+	if *reps < 1 {
+		log.Fatal("-reps must be positive")
+	}
+	var w resultWriter
+	switch *format {
+	case "csv":
+		w = newCSVWriter(os.Stdout)
+	case "json":
+		w = newJSONWriter(os.Stdout)
+	default:
+		log.Fatalf("Unknown -format %q", *format)
+	}
+
+	tmp, err := os.MkdirTemp(*baseDir, "dcache")
 	if err != nil {
 		log.Fatal(err)
 	}
 	defer os.RemoveAll(tmp)
+	log.Println("Using temp dir", tmp)
 
-	if err := touch(filepath.Join(tmp, "y")); err != nil {
-		log.Fatal(err)
+	e := &experiment{dir: tmp, opName: *opName, op: op}
+	for _, n := range ns {
+		for rep := range *reps {
+			r, err := e.trial(n, rep)
+			if err != nil {
+				log.Fatalf("Trial with %d files failed: %s", n, err)
+			}
+			if err := w.write(r); err != nil {
+				log.Fatalln("Error writing results:", err)
+			}
+		}
+	}
+	if err := w.flush(); err != nil {
+		log.Fatalln("Error writing results:", err)
 	}
+}
 
-	start := time.Now()
-	if err := unix.Rmdir(tmp); err == nil {
-		log.Fatal("rmdir succeeded unexpectedly")
+func parseCounts(s string) ([]int, error) {
+	var ns []int
+	for f := range strings.SplitSeq(s, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(f))
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, fmt.Errorf("negative count %d", n)
+		}
+		ns = append(ns, n)
 	}
-	log.Println("First rmdir took", time.Since(start))
+	return ns, nil
+}
+
+// A churnOp creates and then removes the ith file in dir.
+// The graveyard is a scratch directory next to dir.
+type churnOp func(dir, graveyard string, i int) error
 
-	for i := range 50_000 {
-		name := filepath.Join(tmp, strconv.Itoa(i))
+var churnOps = map[string]churnOp{
+	// Create a file and unlink it. This leaves a negative dentry in dir.
+	"unlink": func(dir, _ string, i int) error {
+		name := filepath.Join(dir, strconv.Itoa(i))
 		if err := touch(name); err != nil {
-			log.Fatal(err)
+			return err
 		}
-		if err := os.Remove(name); err != nil {
-			log.Fatal(err)
+		return os.Remove(name)
+	},
+	// Create a file, rename it out of dir, and unlink it there.
+	"rename": func(dir, graveyard string, i int) error {
+		name := strconv.Itoa(i)
+		if err := touch(filepath.Join(dir, name)); err != nil {
+			return err
+		}
+		dead := filepath.Join(graveyard, name)
+		if err := os.Rename(filepath.Join(dir, name), dead); err != nil {
+			return err
+		}
+		return os.Remove(dead)
+	},
+	// Create an unnamed file with O_TMPFILE, which never gets a dentry in
+	// dir at all. This is the control.
+	"tmpfile": func(dir, _ string, _ int) error {
+		fd, err := unix.Open(dir, unix.O_TMPFILE|unix.O_WRONLY, 0o644)
+		if err != nil {
+			return &os.PathError{Op: "open", Path: dir, Err: err}
+		}
+		return unix.Close(fd)
+	},
+}
+
+type experiment struct {
+	dir    string
+	opName string
+	op     churnOp
+}
+
+type result struct {
+	Op          string        `json:"op"`
+	Files       int           `json:"files"`
+	Rep         int           `json:"rep"`
+	Churn       time.Duration `json:"churn_ns"`
+	RmdirBefore time.Duration `json:"rmdir_before_ns"`
+	RmdirAfter  time.Duration `json:"rmdir_after_ns"`
+	Dentry      dentryState   `json:"dentry_state"`
+	Slab        slabStats     `json:"dentry_slab"`
+}
+
+func (e *experiment) trial(n, rep int) (*result, error) {
+	dir, err := os.MkdirTemp(e.dir, "trial")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	graveyard, err := os.MkdirTemp(e.dir, "graveyard")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(graveyard)
+
+	// Keep dir non-empty so that every rmdir fails with ENOTEMPTY after
+	// scanning the directory's dentries.
+	if err := touch(filepath.Join(dir, "y")); err != nil {
+		return nil, err
+	}
+
+	r := &result{Op: e.opName, Files: n, Rep: rep}
+	if r.RmdirBefore, err = timeRmdir(dir); err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	for i := range n {
+		if err := e.op(dir, graveyard, i); err != nil {
+			return nil, err
 		}
 	}
+	r.Churn = time.Since(start)
+	if r.RmdirAfter, err = timeRmdir(dir); err != nil {
+		return nil, err
+	}
+	if r.Dentry, err = readDentryState(); err != nil {
+		return nil, err
+	}
+	if r.Slab, err = readSlabStats("dentry"); err != nil {
+		// Not fatal: some kernels/configs hide slab stats from us.
+		warnOnce(err)
+	}
+	return r, nil
+}
 
-	start = time.Now()
-	if err := unix.Rmdir(tmp); err == nil {
-		log.Fatal("rmdir succeeded unexpectedly")
+// timeRmdir times an rmdir of dir, which must fail with ENOTEMPTY.
+func timeRmdir(dir string) (time.Duration, error) {
+	start := time.Now()
+	err := unix.Rmdir(dir)
+	elapsed := time.Since(start)
+	if !errors.Is(err, unix.ENOTEMPTY) {
+		return 0, fmt.Errorf("rmdir %s: got err=%v; want ENOTEMPTY", dir, err)
+	}
+	return elapsed, nil
+}
+
+var warned bool
+
+func warnOnce(err error) {
+	if !warned {
+		log.Println("Warning:", err)
+		warned = true
 	}
-	log.Println("Second rmdir took", time.Since(start))
 }
 
 func touch(name string) error {
@@ -60,3 +232,64 @@ func touch(name string) error {
 	}
 	return f.Close()
 }
+
+type resultWriter interface {
+	write(*result) error
+	flush() error
+}
+
+type csvWriter struct {
+	w           *csv.Writer
+	wroteHeader bool
+}
+
+func newCSVWriter(w io.Writer) *csvWriter {
+	return &csvWriter{w: csv.NewWriter(w)}
+}
+
+var csvHeader = []string{
+	"op", "files", "rep", "churn_ns", "rmdir_before_ns", "rmdir_after_ns",
+	"nr_dentry", "nr_unused", "age_limit", "want_pages", "nr_negative",
+	"slab_active_objs", "slab_num_objs", "slab_objsize", "slab_num_slabs",
+}
+
+func (w *csvWriter) write(r *result) error {
+	if !w.wroteHeader {
+		if err := w.w.Write(csvHeader); err != nil {
+			return err
+		}
+		w.wroteHeader = true
+	}
+	row := []string{r.Op}
+	for _, n := range []int64{
+		int64(r.Files), int64(r.Rep),
+		r.Churn.Nanoseconds(), r.RmdirBefore.Nanoseconds(), r.RmdirAfter.Nanoseconds(),
+		r.Dentry.NrDentry, r.Dentry.NrUnused, r.Dentry.AgeLimit, r.Dentry.WantPages, r.Dentry.NrNegative,
+		r.Slab.ActiveObjs, r.Slab.NumObjs, r.Slab.ObjSize, r.Slab.NumSlabs,
+	} {
+		row = append(row, strconv.FormatInt(n, 10))
+	}
+	if err := w.w.Write(row); err != nil {
+		return err
+	}
+	// Flush each row so that partial results survive a long sweep.
+	w.w.Flush()
+	return w.w.Error()
+}
+
+func (w *csvWriter) flush() error {
+	w.w.Flush()
+	return w.w.Error()
+}
+
+// jsonWriter writes one JSON object per line.
+type jsonWriter struct {
+	enc *json.Encoder
+}
+
+func newJSONWriter(w io.Writer) *jsonWriter {
+	return &jsonWriter{enc: json.NewEncoder(w)}
+}
+
+func (w *jsonWriter) write(r *result) error { return w.enc.Encode(r) }
+func (w *jsonWriter) flush() error          { return nil }
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// dentryState holds the fields of /proc/sys/fs/dentry-state.
+type dentryState struct {
+	NrDentry   int64 `json:"nr_dentry"`
+	NrUnused   int64 `json:"nr_unused"`
+	AgeLimit   int64 `json:"age_limit"`
+	WantPages  int64 `json:"want_pages"`
+	NrNegative int64 `json:"nr_negative"`
+}
+
+func readDentryState() (dentryState, error) {
+	var ds dentryState
+	b, err := os.ReadFile("/proc/sys/fs/dentry-state")
+	if err != nil {
+		return ds, err
+	}
+	// Before Linux 5.0, nr_negative was an unused dummy field (always 0).
+	fields := strings.Fields(string(b))
+	if len(fields) < 5 {
+		return ds, fmt.Errorf("malformed dentry-state %q", b)
+	}
+	for i, dst := range []*int64{&ds.NrDentry, &ds.NrUnused, &ds.AgeLimit, &ds.WantPages, &ds.NrNegative} {
+		n, err := strconv.ParseInt(fields[i], 10, 64)
+		if err != nil {
+			return ds, fmt.Errorf("malformed dentry-state %q: %s", b, err)
+		}
+		*dst = n
+	}
+	return ds, nil
+}
+
+// slabStats describes a single slab cache.
+type slabStats struct {
+	ActiveObjs int64 `json:"active_objs"`
+	NumObjs    int64 `json:"num_objs"`
+	ObjSize    int64 `json:"objsize"`
+	NumSlabs   int64 `json:"num_slabs"`
+}
+
+// readSlabStats reads the stats for the named slab cache. It uses
+// /sys/kernel/slab (SLUB), which is world-readable, and falls back to
+// /proc/slabinfo, which usually requires root.
+func readSlabStats(cache string) (slabStats, error) {
+	ss, err := readSysSlab(cache)
+	if err == nil {
+		return ss, nil
+	}
+	ss, err1 := readProcSlabinfo(cache)
+	if err1 == nil {
+		return ss, nil
+	}
+	return ss, fmt.Errorf("cannot read slab stats for %s: %s; %s", cache, err, err1)
+}
+
+func readSysSlab(cache string) (slabStats, error) {
+	var ss slabStats
+	dir := filepath.Join("/sys/kernel/slab", cache)
+	for _, f := range []struct {
+		name string
+		dst  *int64
+	}{
+		{"objects", &ss.ActiveObjs},
+		{"total_objects", &ss.NumObjs},
+		{"object_size", &ss.ObjSize},
+		{"slabs", &ss.NumSlabs},
+	} {
+		b, err := os.ReadFile(filepath.Join(dir, f.name))
+		if err != nil {
+			return ss, err
+		}
+		// These look like "40558 N0=40558".
+		fields := strings.Fields(string(b))
+		if len(fields) == 0 {
+			return ss, fmt.Errorf("empty %s/%s", dir, f.name)
+		}
+		n, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			return ss, fmt.Errorf("malformed %s/%s: %s", dir, f.name, err)
+		}
+		*f.dst = n
+	}
+	return ss, nil
+}
+
+func readProcSlabinfo(cache string) (slabStats, error) {
+	var ss slabStats
+	b, err := os.ReadFile("/proc/slabinfo")
+	if err != nil {
+		return ss, err
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for scanner.Scan() {
+		// name active_objs num_objs objsize objperslab pagesperslab
+		//   : tunables ... : slabdata active_slabs num_slabs sharedavail
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 16 || fields[0] != cache {
+			continue
+		}
+		for _, f := range []struct {
+			i   int
+			dst *int64
+		}{
+			{1, &ss.ActiveObjs},
+			{2, &ss.NumObjs},
+			{3, &ss.ObjSize},
+			{14, &ss.NumSlabs},
+		} {
+			n, err := strconv.ParseInt(fields[f.i], 10, 64)
+			if err != nil {
+				return ss, fmt.Errorf("malformed slabinfo line %q: %s", scanner.Text(), err)
+			}
+			*f.dst = n
+		}
+		return ss, nil
+	}
+	return ss, fmt.Errorf("no %s cache in /proc/slabinfo", cache)
+}
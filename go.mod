@@ -26,7 +26,6 @@ require (
 	golang.org/x/tools v0.30.0
 	google.golang.org/api v0.190.0
 	google.golang.org/protobuf v1.34.2
-	rsc.io/edit v1.0.0
 )
 
 require (
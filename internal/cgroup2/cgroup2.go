@@ -0,0 +1,138 @@
+// Package cgroup2 finds the calling process's cgroup v2 group and prepares
+// it to hold groups for the commands that the process runs.
+package cgroup2
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// OwnDir returns the directory of the calling process's group.
+func OwnDir() (string, error) {
+	mountinfo, err := os.ReadFile("/proc/self/mountinfo")
+	if err != nil {
+		return "", err
+	}
+	self, err := os.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return "", err
+	}
+	return FindDir(mountinfo, self)
+}
+
+// FindDir finds the directory of a process's group, given the contents of
+// its /proc/PID/mountinfo and /proc/PID/cgroup.
+func FindDir(mountinfo, cgroup []byte) (string, error) {
+	var path string
+	for line := range strings.Lines(string(cgroup)) {
+		if p, ok := strings.CutPrefix(strings.TrimSpace(line), "0::"); ok {
+			path = p
+			break
+		}
+	}
+	if path == "" {
+		return "", errors.New("not in a cgroup v2 hierarchy")
+	}
+	mounted := false
+	for line := range strings.Lines(string(mountinfo)) {
+		// See proc_pid_mountinfo(5). The optional fields end with a
+		// single -, after which comes the filesystem type:
+		// 42 32 0:38 / /sys/fs/cgroup/unified rw,relatime - cgroup2 cgroup2 rw
+		pre, post, ok := strings.Cut(line, " - ")
+		if !ok {
+			continue
+		}
+		fields, postFields := strings.Fields(pre), strings.Fields(post)
+		if len(fields) < 5 || len(postFields) < 1 || postFields[0] != "cgroup2" {
+			continue
+		}
+		mounted = true
+		root, mountPoint := unescapeMountinfo(fields[3]), unescapeMountinfo(fields[4])
+		rel, ok := strings.CutPrefix(path, root)
+		if !ok {
+			continue
+		}
+		return filepath.Join(mountPoint, rel), nil
+	}
+	if mounted {
+		return "", fmt.Errorf("cgroup %s is not under any cgroup2 mount", path)
+	}
+	return "", errors.New("no cgroup2 filesystem mounted")
+}
+
+// unescapeMountinfo undoes the octal escapes (such as \040 for a space)
+// in a mountinfo path.
+func unescapeMountinfo(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			if n, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(n))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// EnableControllers enables controllers for the children of dir, the
+// calling process's group.
+//
+// Outside the root group, cgroup v2 won't enable a controller for a
+// group's children while the group itself has processes in it. So unless
+// the controllers are already enabled, the calling process first moves
+// into leaf, a new child of dir. If the controllers still can't be enabled
+// (because dir has other processes in it), the process moves back.
+//
+// The process can't leave the leaf once the controllers are enabled, so
+// the leaf is left behind when it exits. Every process that uses the same
+// leaf shares it, and RemoveLeaf cleans it up once it is empty.
+func EnableControllers(dir, leaf string, controllers []string) error {
+	subtreeControl := filepath.Join(dir, "cgroup.subtree_control")
+	b, err := os.ReadFile(subtreeControl)
+	if err != nil {
+		return err
+	}
+	var enable []string
+	for _, c := range controllers {
+		if !slices.Contains(strings.Fields(string(b)), c) {
+			enable = append(enable, "+"+c)
+		}
+	}
+	if len(enable) == 0 {
+		return nil
+	}
+	if err := os.Mkdir(leaf, 0o755); err != nil && !errors.Is(err, fs.ErrExist) {
+		return fmt.Errorf("cannot create cgroup: %s", err)
+	}
+	if err := os.WriteFile(filepath.Join(leaf, "cgroup.procs"), []byte("0"), 0); err != nil {
+		unix.Rmdir(leaf)
+		return fmt.Errorf("cannot move into cgroup %s: %s", leaf, err)
+	}
+	if err := os.WriteFile(subtreeControl, []byte(strings.Join(enable, " ")), 0); err != nil {
+		os.WriteFile(filepath.Join(dir, "cgroup.procs"), []byte("0"), 0)
+		RemoveLeaf(leaf)
+		return fmt.Errorf("cannot enable controllers (%s) in %s: %s", strings.Join(enable, " "), dir, err)
+	}
+	return nil
+}
+
+// RemoveLeaf removes leaf, a group made by EnableControllers, if no
+// process is in it (including, perhaps, one left behind by an earlier
+// process).
+func RemoveLeaf(leaf string) {
+	unix.Rmdir(leaf) // fails if any process is in it
+}
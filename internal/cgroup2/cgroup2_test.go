@@ -0,0 +1,84 @@
+package cgroup2
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFindDir(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		want    string
+		wantErr bool
+	}{
+		{"unified", "/sys/fs/cgroup/user.slice/user-1000.slice/user@1000.service/app.slice/build.scope", false},
+		{"hybrid", "/sys/fs/cgroup/unified", false},
+		{"container", "/sys/fs/cgroup/sub", false},
+		{"escaped", "/sys/fs/cgroup/my cgroups/box", false},
+		{"v1", "", true},
+	} {
+		mountinfo, err := os.ReadFile(filepath.Join("testdata", "mountinfo-"+tt.name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		self, err := os.ReadFile(filepath.Join("testdata", "self-"+tt.name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := FindDir(mountinfo, self)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: got %q; want error", tt.name, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: got %q; want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestEnableControllers(t *testing.T) {
+	// In a plain directory, the control files are ordinary files, so we
+	// can check what gets written where.
+	dir := t.TempDir()
+	subtreeControl := filepath.Join(dir, "cgroup.subtree_control")
+	if err := os.WriteFile(subtreeControl, []byte("cpu\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	leaf := filepath.Join(dir, "leaf")
+	if err := EnableControllers(dir, leaf, []string{"memory", "cpu", "pids"}); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{
+		filepath.Join(leaf, "cgroup.procs"): "0",
+		subtreeControl:                      "+memory +pids",
+	} {
+		b, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != want {
+			t.Errorf("%s: got %q; want %q", name, b, want)
+		}
+	}
+
+	// With the controllers already enabled, we stay put.
+	if err := os.RemoveAll(leaf); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(subtreeControl, []byte("cpu memory\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := EnableControllers(dir, leaf, []string{"cpu"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(leaf); !os.IsNotExist(err) {
+		t.Errorf("created %s although the controllers were enabled", leaf)
+	}
+}
@@ -142,7 +142,7 @@ func InsertSort(headRef **Node) {
 
 func Append(aRef, bRef **Node) {
 	for *aRef != nil {
-		*aRef = (*aRef).Next
+		aRef = &(*aRef).Next
 	}
 	*aRef = *bRef
 	*bRef = nil
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// The tests in this file check the list functions against a simple model:
+// every operation is applied both to a *Node list and to an []int, and after
+// each step the two must agree.
+
+type opKind uint8
+
+const (
+	opPush opKind = iota
+	opPop
+	opInsertNth
+	opSortedInsert
+	opAppend
+	opDeleteList
+	opInsertSort
+
+	numOpKinds
+)
+
+type op struct {
+	kind  opKind
+	index int   // opInsertNth; never negative
+	data  int   // opPush, opInsertNth, opSortedInsert
+	tail  []int // opAppend
+}
+
+func (o op) String() string {
+	switch o.kind {
+	case opPush:
+		return fmt.Sprintf("Push(%d)", o.data)
+	case opPop:
+		return "Pop()"
+	case opInsertNth:
+		return fmt.Sprintf("InsertNth(%d, %d)", o.index, o.data)
+	case opSortedInsert:
+		return fmt.Sprintf("SortedInsert(%d)", o.data)
+	case opAppend:
+		return fmt.Sprintf("Append(%v)", o.tail)
+	case opDeleteList:
+		return "DeleteList()"
+	case opInsertSort:
+		return "InsertSort()"
+	default:
+		return fmt.Sprintf("op(%d)", o.kind)
+	}
+}
+
+func formatOps(ops []op) string {
+	var b strings.Builder
+	for i, o := range ops {
+		fmt.Fprintf(&b, "\n\t%d: %s", i, o)
+	}
+	return b.String()
+}
+
+// catch calls fn and returns the value it panicked with, if any.
+func catch(fn func()) (v any) {
+	defer func() { v = recover() }()
+	fn()
+	return nil
+}
+
+func checkPanic(name string, got any, want error) error {
+	switch {
+	case got == nil && want == nil:
+		return nil
+	case got == nil:
+		return fmt.Errorf("%s: did not panic; want panic(%v)", name, want)
+	case want == nil:
+		return fmt.Errorf("%s: unexpected panic(%v)", name, got)
+	}
+	if err, ok := got.(error); !ok || !errors.Is(err, want) {
+		return fmt.Errorf("%s: got panic(%v); want panic(%v)", name, got, want)
+	}
+	return nil
+}
+
+// apply applies o to both the list and the model and returns the new model.
+func apply(headRef **Node, model []int, o op) ([]int, error) {
+	switch o.kind {
+	case opPush:
+		Push(headRef, o.data)
+		return slices.Insert(model, 0, o.data), nil
+	case opPop:
+		var got int
+		p := catch(func() { got = Pop(headRef) })
+		if len(model) == 0 {
+			return model, checkPanic("Pop", p, errPopEmpty)
+		}
+		if err := checkPanic("Pop", p, nil); err != nil {
+			return model, err
+		}
+		if got != model[0] {
+			return model, fmt.Errorf("Pop: got %d; want %d", got, model[0])
+		}
+		return model[1:], nil
+	case opInsertNth:
+		p := catch(func() { InsertNth(headRef, o.index, o.data) })
+		if o.index > len(model) {
+			return model, checkPanic("InsertNth", p, errOutOfRange)
+		}
+		if err := checkPanic("InsertNth", p, nil); err != nil {
+			return model, err
+		}
+		return slices.Insert(model, o.index, o.data), nil
+	case opSortedInsert:
+		SortedInsert(headRef, &Node{Data: o.data})
+		i := 0
+		for i < len(model) && model[i] < o.data {
+			i++
+		}
+		return slices.Insert(model, i, o.data), nil
+	case opAppend:
+		b := FromSlice(o.tail...)
+		Append(headRef, &b)
+		if b != nil {
+			return model, errors.New("Append: second list not nil afterwards")
+		}
+		return append(model, o.tail...), nil
+	case opDeleteList:
+		DeleteList(headRef)
+		return nil, nil
+	case opInsertSort:
+		InsertSort(headRef)
+		slices.Sort(model)
+		return model, nil
+	default:
+		panic("bad op kind")
+	}
+}
+
+// check verifies that the list agrees with the model.
+func check(head *Node, model []int) error {
+	if got := ToSlice(head); !slices.Equal(got, model) {
+		return fmt.Errorf("ToSlice: got %v; want %v", got, model)
+	}
+	if got := Length(head); got != len(model) {
+		return fmt.Errorf("Length: got %d; want %d", got, len(model))
+	}
+	for _, v := range append(slices.Clone(model), -1000) {
+		var want int
+		for _, w := range model {
+			if w == v {
+				want++
+			}
+		}
+		if got := Count(head, v); got != want {
+			return fmt.Errorf("Count(%d): got %d; want %d", v, got, want)
+		}
+	}
+	for i, want := range model {
+		if got := GetNth(head, i); got != want {
+			return fmt.Errorf("GetNth(%d): got %d; want %d", i, got, want)
+		}
+	}
+	p := catch(func() { GetNth(head, len(model)) })
+	return checkPanic(fmt.Sprintf("GetNth(%d)", len(model)), p, errOutOfRange)
+}
+
+// runOps applies ops in sequence, checking the list against the model after
+// each one. If a check fails, runOps returns an error describing the step.
+func runOps(ops []op) error {
+	var l *Node
+	var model []int
+	for i, o := range ops {
+		var err error
+		model, err = apply(&l, model, o)
+		if err == nil {
+			err = check(l, model)
+		}
+		if err != nil {
+			return fmt.Errorf("after step %d (%s): %s", i, o, err)
+		}
+	}
+	return nil
+}
+
+// shrink returns a smaller sequence of ops that still fails runOps.
+// It repeatedly tries deleting runs of ops and simplifying the arguments
+// of each remaining op until no further change keeps the failure.
+func shrink(ops []op) []op {
+	fails := func(ops []op) bool { return runOps(ops) != nil }
+	for changed := true; changed; {
+		changed = false
+		for n := len(ops) / 2; n >= 1; n /= 2 {
+			for i := 0; i+n <= len(ops); {
+				candidate := slices.Concat(ops[:i], ops[i+n:])
+				if fails(candidate) {
+					ops = candidate
+					changed = true
+				} else {
+					i++
+				}
+			}
+		}
+		for i := range ops {
+			for _, o := range simplifications(ops[i]) {
+				candidate := slices.Clone(ops)
+				candidate[i] = o
+				if fails(candidate) {
+					ops = candidate
+					changed = true
+					break
+				}
+			}
+		}
+	}
+	return ops
+}
+
+// simplifications returns variants of o with smaller arguments.
+func simplifications(o op) []op {
+	var ops []op
+	if o.data != 0 {
+		ops = append(ops, op{kind: o.kind, index: o.index, tail: o.tail})
+	}
+	if o.index > 0 {
+		s := o
+		s.index--
+		ops = append(ops, s)
+	}
+	for i := range o.tail {
+		s := o
+		s.tail = slices.Delete(slices.Clone(o.tail), i, i+1)
+		ops = append(ops, s)
+	}
+	return ops
+}
+
+func randOp(r *rand.Rand) op {
+	// Use a small range of values so that duplicates are common.
+	o := op{
+		kind:  opKind(r.IntN(int(numOpKinds))),
+		index: r.IntN(8),
+		data:  r.IntN(10) - 5,
+	}
+	if o.kind == opAppend {
+		o.tail = make([]int, r.IntN(4))
+		for i := range o.tail {
+			o.tail[i] = r.IntN(10) - 5
+		}
+	}
+	return o
+}
+
+func TestModel(t *testing.T) {
+	for range 500 {
+		seed := rand.Uint64()
+		r := rand.New(rand.NewPCG(seed, 0))
+		ops := make([]op, 1+r.IntN(50))
+		for i := range ops {
+			ops[i] = randOp(r)
+		}
+		if err := runOps(ops); err != nil {
+			ops = shrink(ops)
+			t.Fatalf("seed %d: %s\nminimal failing sequence:%s",
+				seed, runOps(ops), formatOps(ops))
+		}
+	}
+}
+
+// decodeOps turns arbitrary bytes into a sequence of ops.
+func decodeOps(b []byte) []op {
+	var ops []op
+	next := func() int {
+		if len(b) == 0 {
+			return 0
+		}
+		v := b[0]
+		b = b[1:]
+		return int(int8(v))
+	}
+	for len(b) > 0 {
+		o := op{kind: opKind(b[0] % byte(numOpKinds))}
+		b = b[1:]
+		switch o.kind {
+		case opPush, opSortedInsert:
+			o.data = next()
+		case opInsertNth:
+			o.index = next() & 0x7f
+			o.data = next()
+		case opAppend:
+			o.tail = make([]int, next()&3)
+			for i := range o.tail {
+				o.tail[i] = next()
+			}
+		}
+		ops = append(ops, o)
+	}
+	return ops
+}
+
+func FuzzModel(f *testing.F) {
+	f.Add([]byte{})
+	f.Add([]byte{byte(opPop)})
+	f.Add([]byte{byte(opPush), 1, byte(opPush), 2, byte(opInsertSort)})
+	f.Add([]byte{byte(opInsertNth), 0, 3, byte(opInsertNth), 2, 4})
+	f.Add([]byte{byte(opSortedInsert), 5, byte(opAppend), 2, 7, 1, byte(opSortedInsert), 3})
+	f.Add([]byte{byte(opPush), 9, byte(opDeleteList), byte(opPop)})
+	f.Fuzz(func(t *testing.T, b []byte) {
+		ops := decodeOps(b)
+		if err := runOps(ops); err != nil {
+			ops = shrink(ops)
+			t.Fatalf("%s\nminimal failing sequence:%s", runOps(ops), formatOps(ops))
+		}
+	})
+}
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Joining a user namespace requires a single-threaded process, and a Go
+// process never is. So, like os/exec, enterSandbox forks and does all the
+// work in the child with raw syscalls. These runtime hooks (which
+// package syscall uses for the same thing) block signals around the fork
+// and reset the child's signal handlers.
+
+//go:linkname runtimeBeforeFork syscall.runtime_BeforeFork
+func runtimeBeforeFork()
+
+//go:linkname runtimeAfterFork syscall.runtime_AfterFork
+func runtimeAfterFork()
+
+//go:linkname runtimeAfterForkInChild syscall.runtime_AfterForkInChild
+func runtimeAfterForkInChild()
+
+// sandboxNamespaces are the namespaces that exec joins, in order. The user
+// namespace comes first because joining it gives us the capabilities to
+// join the others. The mount namespace is last because it changes our
+// root directory.
+var sandboxNamespaces = []string{"user", "ipc", "uts", "net", "pid", "mnt"}
+
+// enterSteps name the step of entering the sandbox that failed, for the
+// error that the child sends back.
+var enterSteps = []string{
+	"setns",
+	"chroot",
+	"fork",
+	"chdir",
+	"seccomp",
+	"exec",
+}
+
+const (
+	stepSetns = iota
+	stepChroot
+	stepFork
+	stepChdir
+	stepSeccomp
+	stepExec
+)
+
+// enterSandbox runs args in the sandbox s: in its namespaces, chrooted to
+// its root, and with its cgroup, rlimits, and seccomp filter. It returns
+// the PID of a process that exits with the command's status.
+func enterSandbox(s *sandboxState, args []string) (int, error) {
+	proc := fmt.Sprintf("/proc/%d", s.PID)
+
+	// Open everything that the child needs.
+	var nsFDs []int
+	for _, ns := range sandboxNamespaces {
+		name := filepath.Join(proc, "ns", ns)
+		same, err := sameFile(name, filepath.Join("/proc/self/ns", ns))
+		if err != nil {
+			return 0, err
+		}
+		if same {
+			// Joining a namespace that we're already in fails for the
+			// user namespace, and for the others, it would need
+			// capabilities outside the sandbox's user namespace.
+			continue
+		}
+		f, err := os.Open(name)
+		if err != nil {
+			return 0, err
+		}
+		defer f.Close()
+		nsFDs = append(nsFDs, int(f.Fd()))
+	}
+	root, err := os.Open(filepath.Join(proc, "root"))
+	if err != nil {
+		return 0, err
+	}
+	defer root.Close()
+	cgFD := -1
+	if s.Cgroup != "" {
+		// Writing 0 to cgroup.procs moves the writer into the group.
+		// This can fail if the group was removed, which is harmless.
+		if f, err := os.OpenFile(filepath.Join(s.Cgroup, "cgroup.procs"), os.O_WRONLY, 0); err == nil {
+			defer f.Close()
+			cgFD = int(f.Fd())
+		}
+	}
+	var rlimits []enterRlimit
+	for name, n := range s.Spec.Rlimits {
+		rlimits = append(rlimits, enterRlimit{rlimitResources[name], syscall.Rlimit{Cur: n, Max: n}})
+	}
+	var prog *unix.SockFprog
+	if s.Spec.Seccomp != nil {
+		filter := s.Spec.Seccomp.filter()
+		prog = &unix.SockFprog{Len: uint16(len(filter)), Filter: &filter[0]}
+	}
+	workDir := s.Spec.WorkDir
+	if workDir == "" {
+		workDir = "/"
+	}
+	env := append(os.Environ(), s.Spec.Env...)
+	env = append(env, "PS1="+s.Name+"$ ")
+	// We can only look for the command after the chroot, so (like
+	// execvp) try each place that it could be.
+	paths, err := commandPaths(args[0], env)
+	if err != nil {
+		return 0, err
+	}
+	argvp, err := syscall.SlicePtrFromStrings(args)
+	if err != nil {
+		return 0, err
+	}
+	envvp, err := syscall.SlicePtrFromStrings(env)
+	if err != nil {
+		return 0, err
+	}
+	pathps, err := syscall.SlicePtrFromStrings(paths)
+	if err != nil {
+		return 0, err
+	}
+	dot, _ := syscall.BytePtrFromString(".")
+	dir, err := syscall.BytePtrFromString(workDir)
+	if err != nil {
+		return 0, err
+	}
+	zero := []byte("0")
+
+	// The child reports failures on this pipe. It's closed on exec.
+	var p [2]int
+	if err := syscall.Pipe2(p[:], syscall.O_CLOEXEC); err != nil {
+		return 0, err
+	}
+	pid, errno := forkIntoSandbox(nsFDs, int(root.Fd()), dot, cgFD, zero, rlimits, dir, prog, pathps, argvp, envvp, p[1])
+	syscall.Close(p[1])
+	defer syscall.Close(p[0])
+	if errno != 0 {
+		return 0, os.NewSyscallError("fork", errno)
+	}
+	var report [2]uintptr
+	n, err := readFull(p[0], unsafe.Slice((*byte)(unsafe.Pointer(&report)), unsafe.Sizeof(report)))
+	if err != nil {
+		return 0, err
+	}
+	if n > 0 {
+		var ws syscall.WaitStatus
+		syscall.Wait4(pid, &ws, 0, nil)
+		step := "unknown step"
+		if report[0] < uintptr(len(enterSteps)) {
+			step = enterSteps[report[0]]
+		}
+		if report[0] == stepExec {
+			step += " " + args[0]
+		}
+		return 0, fmt.Errorf("cannot enter sandbox %s: %s: %s", s.Name, step, syscall.Errno(report[1]))
+	}
+	return pid, nil
+}
+
+type enterRlimit struct {
+	resource int
+	lim      syscall.Rlimit
+}
+
+// forkIntoSandbox forks a child that joins the namespaces, chroots, moves
+// into the cgroup, sets the rlimits, and then forks again (so that the
+// command is in the sandbox's PID namespace) and waits for the command.
+// The grandchild changes to dir, installs the seccomp filter, and execs
+// the first of paths (a nil-terminated list) that exists. On failure, they write the step and
+// errno to the pipe fd.
+//
+// Everything in the child must be in this function's frame and only call
+// raw syscalls, since it's a copy of a multithreaded Go process with just
+// one thread.
+//
+//go:norace
+//go:noinline
+func forkIntoSandbox(nsFDs []int, rootFD int, dot *byte, cgFD int, zero []byte, rlimits []enterRlimit, dir *byte, prog *unix.SockFprog, paths, argv, envv []*byte, fd int) (pid int, errno syscall.Errno) {
+	var (
+		r1     uintptr
+		err1   syscall.Errno
+		step   uintptr
+		i      int
+		ws     uint32
+		report [2]uintptr
+		code   uintptr
+	)
+	syscall.ForkLock.Lock()
+	runtimeBeforeFork()
+	if runtime.GOARCH == "s390x" {
+		r1, _, err1 = syscall.RawSyscall6(syscall.SYS_CLONE, 0, uintptr(syscall.SIGCHLD), 0, 0, 0, 0)
+	} else {
+		r1, _, err1 = syscall.RawSyscall6(syscall.SYS_CLONE, uintptr(syscall.SIGCHLD), 0, 0, 0, 0, 0)
+	}
+	if err1 != 0 || r1 != 0 {
+		// Parent (or failure).
+		runtimeAfterFork()
+		syscall.ForkLock.Unlock()
+		return int(r1), err1
+	}
+
+	// Child.
+	runtimeAfterForkInChild()
+	step = stepSetns
+	for i = 0; i < len(nsFDs); i++ {
+		if _, _, err1 = syscall.RawSyscall(unix.SYS_SETNS, uintptr(nsFDs[i]), 0, 0); err1 != 0 {
+			goto fail
+		}
+	}
+	step = stepChroot
+	if _, _, err1 = syscall.RawSyscall(syscall.SYS_FCHDIR, uintptr(rootFD), 0, 0); err1 != 0 {
+		goto fail
+	}
+	if _, _, err1 = syscall.RawSyscall(syscall.SYS_CHROOT, uintptr(unsafe.Pointer(dot)), 0, 0); err1 != 0 {
+		goto fail
+	}
+	if cgFD >= 0 {
+		syscall.RawSyscall(syscall.SYS_WRITE, uintptr(cgFD), uintptr(unsafe.Pointer(&zero[0])), uintptr(len(zero)))
+	}
+	for i = 0; i < len(rlimits); i++ {
+		// Setting the limits of the sandbox can't fail unless the
+		// sandbox itself couldn't, so this ignores errors.
+		syscall.RawSyscall6(syscall.SYS_PRLIMIT64, 0, uintptr(rlimits[i].resource), uintptr(unsafe.Pointer(&rlimits[i].lim)), 0, 0, 0)
+	}
+
+	step = stepFork
+	if runtime.GOARCH == "s390x" {
+		r1, _, err1 = syscall.RawSyscall6(syscall.SYS_CLONE, 0, uintptr(syscall.SIGCHLD), 0, 0, 0, 0)
+	} else {
+		r1, _, err1 = syscall.RawSyscall6(syscall.SYS_CLONE, uintptr(syscall.SIGCHLD), 0, 0, 0, 0, 0)
+	}
+	if err1 != 0 {
+		goto fail
+	}
+	if r1 != 0 {
+		// Wait for the command and exit with its status.
+		syscall.RawSyscall(syscall.SYS_CLOSE, uintptr(fd), 0, 0)
+		for {
+			_, _, err1 = syscall.RawSyscall6(syscall.SYS_WAIT4, r1, uintptr(unsafe.Pointer(&ws)), 0, 0, 0, 0)
+			if err1 != syscall.EINTR {
+				break
+			}
+		}
+		// This is what WaitStatus's methods do, but we can't call
+		// them here.
+		if ws&0x7f == 0 {
+			code = uintptr(ws>>8) & 0xff // exited
+		} else {
+			code = 128 + uintptr(ws&0x7f) // killed by a signal
+		}
+		syscall.RawSyscall(syscall.SYS_EXIT_GROUP, code, 0, 0)
+	}
+
+	// Grandchild.
+	step = stepChdir
+	if _, _, err1 = syscall.RawSyscall(syscall.SYS_CHDIR, uintptr(unsafe.Pointer(dir)), 0, 0); err1 != 0 {
+		goto fail
+	}
+	if prog != nil {
+		step = stepSeccomp
+		if _, _, err1 = syscall.RawSyscall6(syscall.SYS_PRCTL, unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0, 0); err1 != 0 {
+			goto fail
+		}
+		if _, _, err1 = syscall.RawSyscall(unix.SYS_SECCOMP, unix.SECCOMP_SET_MODE_FILTER, 0, uintptr(unsafe.Pointer(prog))); err1 != 0 {
+			goto fail
+		}
+	}
+	step = stepExec
+	err1 = syscall.ENOENT
+	for i = 0; paths[i] != nil; i++ {
+		_, _, err1 = syscall.RawSyscall(syscall.SYS_EXECVE, uintptr(unsafe.Pointer(paths[i])), uintptr(unsafe.Pointer(&argv[0])), uintptr(unsafe.Pointer(&envv[0])))
+		if err1 != syscall.ENOENT && err1 != syscall.ENOTDIR {
+			break
+		}
+	}
+
+fail:
+	report[0] = step
+	report[1] = uintptr(err1)
+	syscall.RawSyscall(syscall.SYS_WRITE, uintptr(fd), uintptr(unsafe.Pointer(&report)), unsafe.Sizeof(report))
+	for {
+		syscall.RawSyscall(syscall.SYS_EXIT_GROUP, 253, 0, 0)
+	}
+}
+
+// commandPaths lists the paths (in the sandbox) that the command name
+// could be at: name itself if it contains a slash, or else name in each
+// directory of the PATH in env.
+func commandPaths(name string, env []string) ([]string, error) {
+	if strings.Contains(name, "/") {
+		return []string{name}, nil
+	}
+	path := "/usr/local/bin:/usr/bin:/bin"
+	for _, kv := range env {
+		if p, ok := strings.CutPrefix(kv, "PATH="); ok {
+			path = p
+		}
+	}
+	var paths []string
+	for _, dir := range filepath.SplitList(path) {
+		if filepath.IsAbs(dir) {
+			paths = append(paths, filepath.Join(dir, name))
+		}
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no absolute directories in PATH to find %s in", name)
+	}
+	return paths, nil
+}
+
+func sameFile(name0, name1 string) (bool, error) {
+	fi0, err := os.Stat(name0)
+	if err != nil {
+		return false, err
+	}
+	fi1, err := os.Stat(name1)
+	if err != nil {
+		return false, err
+	}
+	return os.SameFile(fi0, fi1), nil
+}
+
+// readFull reads from fd until b is full or EOF, returning the number of
+// bytes read.
+func readFull(fd int, b []byte) (int, error) {
+	n := 0
+	for n < len(b) {
+		m, err := syscall.Read(fd, b[n:])
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			return n, err
+		}
+		if m == 0 {
+			break
+		}
+		n += m
+	}
+	if n > 0 && n < len(b) {
+		return n, errors.New("short report from sandbox child")
+	}
+	return n, nil
+}
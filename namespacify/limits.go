@@ -0,0 +1,171 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/cespare/misc/internal/cgroup2"
+	"golang.org/x/sys/unix"
+)
+
+// rlimitResources maps the names accepted by -rlimit to resources.
+var rlimitResources = map[string]int{
+	"as":         unix.RLIMIT_AS,
+	"core":       unix.RLIMIT_CORE,
+	"cpu":        unix.RLIMIT_CPU,
+	"data":       unix.RLIMIT_DATA,
+	"fsize":      unix.RLIMIT_FSIZE,
+	"locks":      unix.RLIMIT_LOCKS,
+	"memlock":    unix.RLIMIT_MEMLOCK,
+	"msgqueue":   unix.RLIMIT_MSGQUEUE,
+	"nice":       unix.RLIMIT_NICE,
+	"nofile":     unix.RLIMIT_NOFILE,
+	"nproc":      unix.RLIMIT_NPROC,
+	"rss":        unix.RLIMIT_RSS,
+	"rtprio":     unix.RLIMIT_RTPRIO,
+	"rttime":     unix.RLIMIT_RTTIME,
+	"sigpending": unix.RLIMIT_SIGPENDING,
+	"stack":      unix.RLIMIT_STACK,
+}
+
+// setRlimits sets the soft and hard limits of the current process (and so
+// of the command that it starts).
+func setRlimits(rlimits map[string]uint64) error {
+	for name, n := range rlimits {
+		// syscall.Setrlimit (unlike unix.Setrlimit) tells os/exec not
+		// to restore the original RLIMIT_NOFILE in child processes.
+		if err := syscall.Setrlimit(rlimitResources[name], &syscall.Rlimit{Cur: n, Max: n}); err != nil {
+			return fmt.Errorf("cannot set rlimit %s to %d: %s", name, n, err)
+		}
+	}
+	return nil
+}
+
+// memorySizeRegexp matches the sizes that memory.max accepts: a number of
+// bytes with an optional k, m, or g suffix.
+var memorySizeRegexp = regexp.MustCompile(`^[0-9]+[kmgKMG]?$`)
+
+// A cgroup is a cgroup v2 group that limits the resources of the sandbox.
+// It is a sub-group of namespacify's own cgroup, which must have been
+// delegated to the user (as systemd does with Delegate=yes).
+type cgroup struct {
+	dir string
+	// f is the open group directory, for starting the sandbox in the
+	// group with CLONE_INTO_CGROUP.
+	f *os.File
+	// leaf is the group that namespacify may have moved into to enable
+	// the controllers (see cgroup2.EnableControllers).
+	leaf string
+}
+
+// A cgroupLimit is a value to write to a cgroup file.
+type cgroupLimit struct {
+	controller string
+	file       string
+	value      string
+}
+
+// cgroupLimits lists the cgroup settings for the limits in s.
+func cgroupLimits(s *spec) []cgroupLimit {
+	var limits []cgroupLimit
+	if s.Memory != "" {
+		limits = append(limits,
+			cgroupLimit{"memory", "memory.max", s.Memory},
+			// Don't let the sandbox get around the limit by swapping.
+			cgroupLimit{"memory", "memory.swap.max", "0"},
+		)
+	}
+	if s.Pids > 0 {
+		limits = append(limits, cgroupLimit{"pids", "pids.max", strconv.Itoa(s.Pids)})
+	}
+	if s.CPU > 0 {
+		const period = 100000 // µs
+		quota := int(s.CPU * period)
+		limits = append(limits, cgroupLimit{"cpu", "cpu.max", fmt.Sprintf("%d %d", quota, period)})
+	}
+	return limits
+}
+
+// newCgroup creates a cgroup with the limits of s. It returns nil if s has
+// no cgroup limits.
+func newCgroup(s *spec) (*cgroup, error) {
+	limits := cgroupLimits(s)
+	if len(limits) == 0 {
+		return nil, nil
+	}
+	parent, err := cgroup2.OwnDir()
+	if err != nil {
+		return nil, err
+	}
+	var controllers []string
+	for _, l := range limits {
+		if !slices.Contains(controllers, l.controller) {
+			controllers = append(controllers, l.controller)
+		}
+	}
+	leaf := filepath.Join(parent, "namespacify")
+	if err := cgroup2.EnableControllers(parent, leaf, controllers); err != nil {
+		return nil, err
+	}
+	dir := filepath.Join(parent, fmt.Sprintf("namespacify-%d", os.Getpid()))
+	if err := os.Mkdir(dir, 0755); err != nil {
+		return nil, err
+	}
+	cg := &cgroup{dir: dir, leaf: leaf}
+	for _, l := range limits {
+		err := os.WriteFile(filepath.Join(dir, l.file), []byte(l.value), 0)
+		if err != nil && !(l.file == "memory.swap.max" && errors.Is(err, os.ErrNotExist)) {
+			cg.remove()
+			return nil, err
+		}
+	}
+	if cg.f, err = os.Open(dir); err != nil {
+		cg.remove()
+		return nil, err
+	}
+	return cg, nil
+}
+
+// oomKills returns the number of processes in the group that the OOM
+// killer killed.
+func (cg *cgroup) oomKills() (int, error) {
+	b, err := os.ReadFile(filepath.Join(cg.dir, "memory.events"))
+	if err != nil {
+		return 0, err
+	}
+	for line := range strings.Lines(string(b)) {
+		if n, ok := strings.CutPrefix(strings.TrimSpace(line), "oom_kill "); ok {
+			return strconv.Atoi(n)
+		}
+	}
+	return 0, nil
+}
+
+// remove removes the group, along with the leaf group if it is empty. The
+// sandbox's processes may take a moment to leave the group after the
+// sandbox's init process exits.
+func (cg *cgroup) remove() error {
+	if cg.f != nil {
+		cg.f.Close()
+	}
+	var err error
+	for range 100 {
+		if err = syscall.Rmdir(cg.dir); err != syscall.EBUSY {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		return &os.PathError{Op: "rmdir", Path: cg.dir, Err: err}
+	}
+	cgroup2.RemoveLeaf(cg.leaf)
+	return nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/cespare/misc/internal/cgroup2"
+	"golang.org/x/sys/unix"
+)
+
+func TestCgroupLimits(t *testing.T) {
+	s := defaultSpec()
+	if limits := cgroupLimits(s); len(limits) != 0 {
+		t.Errorf("default spec has cgroup limits %v", limits)
+	}
+	s.Memory = "512m"
+	s.Pids = 100
+	s.CPU = 1.5
+	want := []cgroupLimit{
+		{"memory", "memory.max", "512m"},
+		{"memory", "memory.swap.max", "0"},
+		{"pids", "pids.max", "100"},
+		{"cpu", "cpu.max", "150000 100000"},
+	}
+	if got := cgroupLimits(s); !reflect.DeepEqual(got, want) {
+		t.Errorf("got limits %v; want %v", got, want)
+	}
+}
+
+// TestNewCgroup checks that the limits work wherever they could: it only
+// skips if our cgroup isn't delegated to us or lacks the controllers.
+func TestNewCgroup(t *testing.T) {
+	dir, err := cgroup2.OwnDir()
+	if err != nil {
+		t.Skip("no cgroup v2 group:", err)
+	}
+	if err := unix.Access(dir, unix.W_OK); err != nil {
+		t.Skipf("cgroup %s is not delegated to us: %s", dir, err)
+	}
+	b, err := os.ReadFile(filepath.Join(dir, "cgroup.controllers"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []string{"memory", "pids"} {
+		if !slices.Contains(strings.Fields(string(b)), c) {
+			t.Skipf("the %s controller is not available in %s", c, dir)
+		}
+	}
+	cg, err := newCgroup(&spec{Memory: "32m", Pids: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cg.remove()
+	for file, want := range map[string]string{
+		"memory.max": "33554432",
+		"pids.max":   "10",
+	} {
+		b, err := os.ReadFile(filepath.Join(cg.dir, file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.TrimSpace(string(b)); got != want {
+			t.Errorf("%s: got %q; want %q", file, got, want)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+//go:build ignore
+
+// mksyscalls generates the tables of syscall numbers (zsyscalls_*.go) from
+// the SYS_* constants in golang.org/x/sys/unix.
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+var arches = map[string]string{
+	"amd64": "AUDIT_ARCH_X86_64",
+	"arm64": "AUDIT_ARCH_AARCH64",
+}
+
+func main() {
+	log.SetFlags(0)
+	out, err := exec.Command("go", "list", "-m", "-f", "{{.Dir}}", "golang.org/x/sys").Output()
+	if err != nil {
+		log.Fatalln("Cannot find golang.org/x/sys:", err)
+	}
+	dir := filepath.Join(strings.TrimSpace(string(out)), "unix")
+	for arch, auditArch := range arches {
+		if err := generate(dir, arch, auditArch); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+func generate(dir, arch, auditArch string) error {
+	name := filepath.Join(dir, "zsysnum_linux_"+arch+".go")
+	f, err := parser.ParseFile(token.NewFileSet(), name, nil, 0)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// Code generated by mksyscalls.go; DO NOT EDIT.\n\n")
+	fmt.Fprintf(&buf, "package main\n\n")
+	fmt.Fprintf(&buf, "import \"golang.org/x/sys/unix\"\n\n")
+	fmt.Fprintf(&buf, "const auditArch = unix.%s\n\n", auditArch)
+	fmt.Fprintf(&buf, "var syscallNumbers = map[string]uint32{\n")
+	for _, decl := range f.Decls {
+		gd, ok := decl.(*ast.GenDecl)
+		if !ok || gd.Tok != token.CONST {
+			continue
+		}
+		for _, s := range gd.Specs {
+			for _, id := range s.(*ast.ValueSpec).Names {
+				if sys, ok := strings.CutPrefix(id.Name, "SYS_"); ok {
+					fmt.Fprintf(&buf, "\t%q: unix.%s,\n", strings.ToLower(sys), id.Name)
+				}
+			}
+		}
+	}
+	fmt.Fprintf(&buf, "}\n")
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return err
+	}
+	return os.WriteFile("zsyscalls_"+arch+".go", src, 0o644)
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+func mount(source, target, fstype string, flags uintptr, data string) error {
+	if err := syscall.Mount(source, target, fstype, flags, data); err != nil {
+		return &os.PathError{Op: "mount", Path: target, Err: err}
+	}
+	return nil
+}
+
+// mountBind bind-mounts source (a file or directory) at target, creating
+// target if needed.
+func mountBind(source, target string, rw bool) error {
+	fi, err := os.Stat(source)
+	if err != nil {
+		return err
+	}
+	if fi.IsDir() {
+		if err := os.MkdirAll(target, 0755); err != nil {
+			return err
+		}
+	} else {
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		f.Close()
+	}
+	if err := mount(source, target, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+		return err
+	}
+	if rw {
+		return nil
+	}
+	return remountReadOnly(target)
+}
+
+// remountReadOnly makes the bind mount at target read-only. (The kernel
+// ignores MS_RDONLY when creating a bind mount.) In a user namespace, the
+// remount must keep the flags of the original mount, which are locked.
+func remountReadOnly(target string) error {
+	var st unix.Statfs_t
+	if err := unix.Statfs(target, &st); err != nil {
+		return &os.PathError{Op: "statfs", Path: target, Err: err}
+	}
+	flags := uintptr(syscall.MS_BIND | syscall.MS_REMOUNT | syscall.MS_RDONLY)
+	for _, f := range []struct {
+		st int64
+		ms uintptr
+	}{
+		{unix.ST_NOSUID, syscall.MS_NOSUID},
+		{unix.ST_NODEV, syscall.MS_NODEV},
+		{unix.ST_NOEXEC, syscall.MS_NOEXEC},
+		{unix.ST_NOATIME, syscall.MS_NOATIME},
+		{unix.ST_NODIRATIME, syscall.MS_NODIRATIME},
+		{unix.ST_RELATIME, syscall.MS_RELATIME},
+	} {
+		if st.Flags&f.st != 0 {
+			flags |= f.ms
+		}
+	}
+	return mount("", target, "", flags, "")
+}
+
+// pivotRoot makes dir (a mount point) the root directory. Unlike chroot,
+// this unmounts the old root, so there's no way back to it.
+func pivotRoot(dir string) error {
+	if err := os.Chdir(dir); err != nil {
+		return err
+	}
+	// Passing . for both arguments stacks the old root on top of the new
+	// one (see pivot_root(2)), so there's no need for a directory to
+	// hold it.
+	if err := syscall.PivotRoot(".", "."); err != nil {
+		return &os.PathError{Op: "pivot_root", Path: dir, Err: err}
+	}
+	if err := syscall.Unmount(".", syscall.MNT_DETACH); err != nil {
+		return &os.PathError{Op: "unmount", Path: "old root", Err: err}
+	}
+	return os.Chdir("/")
+}
+
+// mountProc mounts a new procfs at target. Since the child is in a new
+// PID namespace, the procfs only shows the sandbox's processes.
+func mountProc(target string) error {
+	if err := mkdir(target); err != nil {
+		return err
+	}
+	return mount("proc", target, "proc", syscall.MS_NOSUID|syscall.MS_NODEV|syscall.MS_NOEXEC, "")
+}
+
+// devices are the host devices that are available in a minimal /dev.
+var devices = []string{"null", "zero", "full", "random", "urandom", "tty"}
+
+// makeDev creates a minimal /dev at target on a new tmpfs. We can't create
+// device nodes in a user namespace, so the devices are bind mounts of the
+// host's.
+func makeDev(target string) error {
+	if err := mkdir(target); err != nil {
+		return err
+	}
+	if err := mount("tmpfs", target, "tmpfs", syscall.MS_NOSUID|syscall.MS_NOEXEC, "mode=0755"); err != nil {
+		return err
+	}
+	for _, name := range devices {
+		dst := filepath.Join(target, name)
+		f, err := os.Create(dst)
+		if err != nil {
+			return err
+		}
+		f.Close()
+		if err := mount(filepath.Join("/dev", name), dst, "", syscall.MS_BIND, ""); err != nil {
+			return err
+		}
+	}
+
+	pts := filepath.Join(target, "pts")
+	if err := mkdir(pts); err != nil {
+		return err
+	}
+	if err := mount("devpts", pts, "devpts", syscall.MS_NOSUID|syscall.MS_NOEXEC, "newinstance,ptmxmode=0666,mode=0620"); err != nil {
+		return err
+	}
+	shm := filepath.Join(target, "shm")
+	if err := mkdir(shm); err != nil {
+		return err
+	}
+	if err := mount("shm", shm, "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV, "mode=1777"); err != nil {
+		return err
+	}
+
+	for name, dst := range map[string]string{
+		"ptmx":   "pts/ptmx",
+		"fd":     "/proc/self/fd",
+		"stdin":  "/proc/self/fd/0",
+		"stdout": "/proc/self/fd/1",
+		"stderr": "/proc/self/fd/2",
+	} {
+		if err := os.Symlink(dst, filepath.Join(target, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tmpSizeRegexp matches the tmpfs size option: a number of bytes with an
+// optional k, m, or g suffix, or a percentage of RAM.
+var tmpSizeRegexp = regexp.MustCompile(`^[0-9]+[kmgKMG%]?$`)
+
+// mountTmp mounts a tmpfs with the given size limit at target.
+func mountTmp(target, size string) error {
+	if err := mkdir(target); err != nil {
+		return err
+	}
+	return mount("tmpfs", target, "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV, "mode=1777,size="+size)
+}
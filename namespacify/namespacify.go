@@ -3,36 +3,174 @@ package main
 import (
 	"crypto/rand"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"flag"
 	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 	"syscall"
 
 	"github.com/cespare/cp"
 )
 
+// The parent passes the childConfig to the re-exec'd child as JSON in the
+// reexecEnv environment variable.
 const reexecEnv = "NAMESPACIFY_REEXEC"
 
+type childConfig struct {
+	Name string `json:"name"`
+	Dir  string `json:"dir"`
+	Spec *spec  `json:"spec"`
+	// Root is the directory holding the extracted Spec.Rootfs, if any.
+	Root string `json:"root"`
+	// WaitIDMaps says that the parent maps the child's IDs with
+	// newuidmap or newgidmap, so the child must wait for it.
+	WaitIDMaps bool `json:"waitIDMaps"`
+}
+
 func main() {
 	log.SetFlags(0)
 
-	if chroot := os.Getenv(reexecEnv); chroot != "" {
-		configureNamespace(chroot)
+	if s := os.Getenv(reexecEnv); s != "" {
+		var cfg childConfig
+		if err := json.Unmarshal([]byte(s), &cfg); err != nil {
+			log.Fatalf("Bad %s: %s", reexecEnv, err)
+		}
+		if cfg.WaitIDMaps {
+			waitForIDMaps(cfg)
+		}
+		os.Unsetenv(reexecEnv)
+		ovl := configureNamespace(cfg)
+		if ovl != nil {
+			if err := ovl.snapshot(); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if err := setRlimits(cfg.Spec.Rlimits); err != nil {
+			log.Fatal(err)
+		}
+		if cfg.Spec.Seccomp != nil {
+			if err := installSeccomp(cfg.Spec.Seccomp); err != nil {
+				log.Fatal(err)
+			}
+		}
 		cmd := exec.Command(os.Args[0], os.Args[1:]...)
 		setStdIO(cmd)
-		exitWithStatus(cmd.Run())
+		err := cmd.Run()
+		if ovl != nil {
+			if err := ovl.printSummary(os.Stderr, cfg.Spec.Keep); err != nil {
+				log.Println("Cannot summarize overlay changes:", err)
+			}
+		}
+		exitWithStatus(err, nil)
+	}
+
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "exec":
+			runExec(os.Args[2:])
+			return
+		case "ls":
+			runLs(os.Args[2:])
+			return
+		case "kill":
+			runKill(os.Args[2:])
+			return
+		}
 	}
 
 	chrootDir := flag.String("dir", "chroot", "Directory for chroot")
+	specFile := flag.String("spec", "", "JSON file describing the sandbox (instead of the flags below)")
+	sp := defaultSpec()
+	flag.StringVar(&sp.Hostname, "name", "", "Name (and hostname) of the sandbox, for namespacify exec and kill (default ns-<random hex>)")
+	flag.StringVar(&sp.Rootfs, "rootfs", "", "Use the tarball `image.tar[.gz]` as the read-only root filesystem, rather than binding the host's system directories")
+	flag.BoolVar(&sp.Overlay, "overlay", false, "Make the root and read-only directory mounts writable with overlays (discarded on exit)")
+	flag.StringVar(&sp.Keep, "keep", "", "With -overlay, keep the changes in this `dir` (rather than discarding them)")
+	flag.StringVar(&sp.Net, "net", sp.Net, "Network: none (a private network namespace with only loopback) or host")
+	flag.BoolVar(&sp.Proc, "proc", sp.Proc, "Mount a new /proc for the sandbox's PID namespace (-proc=false binds the host's, which lists the host's processes)")
+	flag.BoolVar(&sp.Dev, "dev", sp.Dev, "Create a minimal /dev on tmpfs (rather than binding the host's)")
+	flag.StringVar(&sp.TmpSize, "tmp", sp.TmpSize, "Size limit of the tmpfs mounted on /tmp (empty for no /tmp)")
+	flag.StringVar(&sp.Memory, "memory", "", "Limit the sandbox's memory to `bytes` (with an optional k, m, or g suffix)")
+	flag.IntVar(&sp.Pids, "pids", 0, "Limit the number of processes in the sandbox to `n`")
+	flag.Float64Var(&sp.CPU, "cpu", 0, "Limit the sandbox to `n` CPUs (may be fractional)")
+	flag.Var(rlimitFlag{&sp.Rlimits}, "rlimit", "Set the soft and hard rlimit `NAME=N`, such as nofile=1024 (may be repeated)")
+	flag.Var(seccompFlag{&sp.Seccomp}, "seccomp", "Filter the command's syscalls with a `profile`: default (deny a list of dangerous syscalls) or a JSON profile file")
+	flag.BoolVar(&sp.SubIDs, "subids", sp.SubIDs, "Also map the user's subordinate uid and gid ranges (from /etc/subuid and /etc/subgid) with newuidmap and newgidmap, if they are installed")
+	flag.Var(bindFlag{&sp.Mounts}, "bind", "Bind-mount `src:dst[:rw]` (read-only unless rw is given; may be repeated)")
+	flag.Var(copyFlag{&sp.Copies}, "copy", "Copy `src[:dst]` into the sandbox (may be repeated)")
 	flag.Parse()
 	if flag.NArg() < 1 {
-		log.Fatalf("usage: %s command [args...]", os.Args[0])
+		log.Fatalf(`usage: %[1]s [flags] [--] command [args...]
+       %[1]s exec NAME [--] command [args...]
+       %[1]s ls
+       %[1]s kill NAME
+
+(To run a command named exec, ls, or kill in a new sandbox, use --.)`, os.Args[0])
 	}
 	if *chrootDir == "" {
 		log.Fatalln("-chroot cannot be empty")
 	}
+	if sp.Keep != "" {
+		keep, err := filepath.Abs(sp.Keep)
+		if err != nil {
+			log.Fatal(err)
+		}
+		sp.Keep = keep
+	}
+	if *specFile != "" {
+		var conflicts []string
+		flag.Visit(func(f *flag.Flag) {
+			if f.Name != "dir" && f.Name != "spec" {
+				conflicts = append(conflicts, "-"+f.Name)
+			}
+		})
+		if len(conflicts) > 0 {
+			log.Fatalf("Cannot use %s with -spec (set them in the spec file instead)", strings.Join(conflicts, ", "))
+		}
+		var err error
+		sp, err = loadSpec(*specFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+	} else if sp.Rootfs == "" {
+		sp.Mounts = append(hostMounts(), sp.Mounts...)
+		sp.Copies = append(hostCopies(), sp.Copies...)
+	}
+	if err := sp.validate(); err != nil {
+		log.Fatalf("Bad sandbox spec:\n%s", err)
+	}
+	name := sp.Hostname
+	if name == "" {
+		id := make([]byte, 8)
+		if _, err := rand.Read(id); err != nil {
+			log.Fatal(err)
+		}
+		name = "ns-" + hex.EncodeToString(id)
+	}
+	if s, err := loadState(name); err == nil {
+		log.Fatalf("A sandbox named %s is already running (PID %d)", name, s.PID)
+	}
+	var rootDir string
+	if sp.Rootfs != "" {
+		var err error
+		rootDir, err = rootfsDir(sp.Rootfs)
+		if err != nil {
+			log.Fatalln("Cannot unpack rootfs:", err)
+		}
+		if !sp.Overlay {
+			if err := checkMountTargets(rootDir, sp.Mounts); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+	if sp.Keep != "" {
+		if err := os.MkdirAll(sp.Keep, 0755); err != nil {
+			log.Fatalln("Cannot create -keep dir:", err)
+		}
+	}
 	if err := os.RemoveAll(*chrootDir); err != nil {
 		if !os.IsExist(err) {
 			log.Fatalln("Cannot clear chroot dir:", err)
@@ -41,10 +179,36 @@ func main() {
 	if err := os.MkdirAll(*chrootDir, 0755); err != nil {
 		log.Fatalln("Cannot create chroot dir:", err)
 	}
+	// Mapping the user's subordinate IDs needs the setuid helpers, which
+	// run after the child has started.
+	var mappers []*idMapper
+	uidMaps := sysProcIDMaps(sp.UIDMap)
+	gidMaps := sysProcIDMaps(sp.GIDMap)
+	if sp.SubIDs {
+		m, err := subIDMapper("newuidmap", "/etc/subuid", sp.UIDMap)
+		if err != nil {
+			log.Println("Warning: cannot map subordinate uids:", err)
+		} else if m != nil {
+			mappers = append(mappers, m)
+			uidMaps = nil
+		}
+		m, err = subIDMapper("newgidmap", "/etc/subgid", sp.GIDMap)
+		if err != nil {
+			log.Println("Warning: cannot map subordinate gids:", err)
+		} else if m != nil {
+			mappers = append(mappers, m)
+			gidMaps = nil
+		}
+	}
+	cfg, err := json.Marshal(childConfig{Name: name, Dir: *chrootDir, Spec: sp, Root: rootDir, WaitIDMaps: len(mappers) > 0})
+	if err != nil {
+		log.Fatal(err)
+	}
+	env := append(os.Environ(), sp.Env...)
 	cmd := &exec.Cmd{
 		Path:        "/proc/self/exe",
 		Args:        flag.Args(),
-		Env:         append(os.Environ(), reexecEnv+"="+*chrootDir),
+		Env:         append(env, reexecEnv+"="+string(cfg)),
 		SysProcAttr: &syscall.SysProcAttr{Pdeathsig: syscall.SIGTERM},
 	}
 	setStdIO(cmd)
@@ -54,86 +218,216 @@ func main() {
 		syscall.CLONE_NEWPID |
 		syscall.CLONE_NEWUSER |
 		syscall.CLONE_NEWUTS
-	uidMappings := []syscall.SysProcIDMap{
-		{
-			ContainerID: 0,
-			HostID:      os.Getuid(),
-			Size:        1,
-		},
-	}
-	gidMappings := []syscall.SysProcIDMap{
-		{
-			ContainerID: 0,
-			HostID:      os.Getgid(),
-			Size:        1,
-		},
+	if sp.Net == "none" {
+		cloneFlags |= syscall.CLONE_NEWNET
 	}
 	cmd.SysProcAttr = &syscall.SysProcAttr{
 		Cloneflags:  uintptr(cloneFlags),
-		UidMappings: uidMappings,
-		GidMappings: gidMappings,
+		UidMappings: uidMaps,
+		GidMappings: gidMaps,
+	}
+	var syncw *os.File
+	if len(mappers) > 0 {
+		syncr, w, err := os.Pipe()
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer syncr.Close()
+		syncw = w
+		cmd.ExtraFiles = []*os.File{syncr} // idMapSyncFD
+	}
+
+	// The memory, pids, and cpu limits need a cgroup v2 group that we can
+	// create sub-groups in.
+	cg, err := newCgroup(sp)
+	if err != nil {
+		log.Printf("Warning: the memory, pids, and cpu limits are not enforced: cannot create a cgroup (namespacify must run in a delegated cgroup v2 group): %s", err)
+	}
+	if cg != nil {
+		cmd.SysProcAttr.UseCgroupFD = true
+		cmd.SysProcAttr.CgroupFD = int(cg.f.Fd())
 	}
 
-	exitWithStatus(cmd.Run())
+	if err := cmd.Start(); err != nil {
+		exitWithStatus(err, cg)
+	}
+	if syncw != nil {
+		for _, m := range mappers {
+			if err := m.apply(cmd.Process.Pid); err != nil {
+				cmd.Process.Kill()
+				cmd.Wait()
+				if cg != nil {
+					cg.remove()
+				}
+				log.Fatalln("Cannot map subordinate IDs:", err)
+			}
+		}
+		syncw.Write([]byte{0})
+		syncw.Close()
+	}
+	state, err := newSandboxState(name, cmd.Process.Pid, flag.Args(), sp, cg)
+	if err == nil {
+		err = state.save()
+	}
+	if err != nil {
+		log.Println("Warning: cannot record the sandbox for exec, ls, and kill:", err)
+		state = nil
+	}
+	err = cmd.Wait()
+	if state != nil {
+		state.remove()
+	}
+	exitWithStatus(err, cg)
 }
 
-func configureNamespace(chroot string) {
-	for _, dir := range []string{
-		"/bin",
-		"/dev",
-		"/lib",
-		"/lib64",
-		"/proc",
-		"/sbin",
-		"/sys",
-		"/usr",
-	} {
-		target := filepath.Join(chroot, dir)
-		if err := mkdir(target); err != nil {
+// configureNamespace sets up the sandbox in the re-exec'd child. If the
+// spec asks for an overlay, it returns the overlays.
+func configureNamespace(cfg childConfig) *overlays {
+	sp := cfg.Spec
+	if sp.Net == "none" {
+		// A new network namespace only has a loopback interface, and
+		// it starts out down.
+		if err := setLinkUp("lo"); err != nil {
+			log.Fatal(err)
+		}
+	}
+	// Keep our mounts from propagating back to the host.
+	if err := mount("", "/", "", syscall.MS_REC|syscall.MS_PRIVATE, ""); err != nil {
+		log.Fatal(err)
+	}
+	// The new root must be a mount point for pivot_root.
+	chroot := cfg.Dir
+	var ovl *overlays
+	copied := false
+	switch {
+	case sp.Overlay:
+		// The overlay's lower dir is the rootfs or else a directory
+		// holding just the copies (which are made first so that they
+		// don't count as changes).
+		lower := cfg.Root
+		if lower == "" {
+			lower = filepath.Join(cfg.Dir, "base")
+			if err := mkdir(lower); err != nil {
+				log.Fatal(err)
+			}
+			if err := copyFiles(lower, sp.Copies); err != nil {
+				log.Fatal(err)
+			}
+			copied = true
+		}
+		var err error
+		ovl, err = newOverlays(filepath.Join(cfg.Dir, "overlay"), sp.Keep)
+		if err != nil {
+			log.Fatal(err)
+		}
+		chroot = filepath.Join(cfg.Dir, "root")
+		if err := mkdir(chroot); err != nil {
+			log.Fatal(err)
+		}
+		if err := ovl.mount(lower, "/", chroot); err != nil {
 			log.Fatal(err)
 		}
-		if err := mountBind(dir, target); err != nil {
+	case cfg.Root != "":
+		if err := mountBind(cfg.Root, chroot, false); err != nil {
+			log.Fatal(err)
+		}
+	default:
+		if err := mountBind(chroot, chroot, true); err != nil {
 			log.Fatal(err)
 		}
 	}
-	for _, p := range []string{
-		"/etc/resolv.conf",
-		"/etc/ssl/certs",
-		"/etc/passwd",
-	} {
-		dst := filepath.Join(chroot, p)
-		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+	mounts := sp.Mounts
+	if !sp.Proc {
+		mounts = append(mounts, bindMount{Source: "/proc", Target: "/proc", RW: true})
+	}
+	if !sp.Dev {
+		mounts = append(mounts, bindMount{Source: "/dev", Target: "/dev", RW: true})
+	}
+	for _, m := range mounts {
+		target := filepath.Join(chroot, m.Target)
+		if ovl != nil && !m.RW {
+			if fi, err := os.Stat(m.Source); err == nil && fi.IsDir() {
+				if err := os.MkdirAll(target, 0755); err != nil {
+					log.Fatal(err)
+				}
+				err := ovl.mount(m.Source, m.Target, target)
+				if err == nil {
+					continue
+				}
+				// Some filesystems (such as sysfs) can't be the lower
+				// dir of an overlay; those stay read-only.
+				if !errors.Is(err, syscall.EINVAL) {
+					log.Fatal(err)
+				}
+			}
+		}
+		if err := mountBind(m.Source, target, m.RW); err != nil {
 			log.Fatal(err)
 		}
-		if err := cp.CopyAll(dst, p); err != nil {
+	}
+	if sp.Proc {
+		if err := mountProc(filepath.Join(chroot, "/proc")); err != nil {
 			log.Fatal(err)
 		}
 	}
-	if err := os.Chdir(chroot); err != nil {
-		log.Fatal(err)
+	if sp.Dev {
+		if err := makeDev(filepath.Join(chroot, "/dev")); err != nil {
+			log.Fatal(err)
+		}
 	}
-	if err := syscall.Chroot("."); err != nil {
-		log.Fatal(err)
+	if sp.TmpSize != "" {
+		if err := mountTmp(filepath.Join(chroot, "/tmp"), sp.TmpSize); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if !copied {
+		if err := copyFiles(chroot, sp.Copies); err != nil {
+			log.Fatal(err)
+		}
 	}
-	id := make([]byte, 8)
-	if _, err := rand.Read(id); err != nil {
+	if err := pivotRoot(chroot); err != nil {
 		log.Fatal(err)
 	}
-	name := "ns-" + hex.EncodeToString(id)
-	os.Setenv("PS1", name+"$ ")
-	if err := syscall.Sethostname([]byte(name)); err != nil {
+	if sp.WorkDir != "" {
+		if err := os.Chdir(sp.WorkDir); err != nil {
+			log.Fatal(err)
+		}
+	}
+	os.Setenv("PS1", cfg.Name+"$ ")
+	if err := syscall.Sethostname([]byte(cfg.Name)); err != nil {
 		log.Fatal(err)
 	}
+	return ovl
 }
 
-func mountBind(source, target string) error {
-	return syscall.Mount(
-		source,
-		target,
-		"", // ignored
-		syscall.MS_BIND|syscall.MS_REC|syscall.MS_RDONLY,
-		"", // ignored
-	)
+// copyFiles makes the copies into root.
+func copyFiles(root string, copies []fileCopy) error {
+	for _, c := range copies {
+		target := c.Target
+		if target == "" {
+			target = c.Source
+		}
+		dst := filepath.Join(root, target)
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return err
+		}
+		if err := cp.CopyAll(dst, c.Source); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sysProcIDMaps(maps []idMap) []syscall.SysProcIDMap {
+	var sysMaps []syscall.SysProcIDMap
+	for _, m := range maps {
+		sysMaps = append(sysMaps, syscall.SysProcIDMap{
+			ContainerID: m.ContainerID,
+			HostID:      m.HostID,
+			Size:        m.Size,
+		})
+	}
+	return sysMaps
 }
 
 func mkdir(dir string) error {
@@ -151,7 +445,21 @@ func setStdIO(cmd *exec.Cmd) {
 	cmd.Stderr = os.Stderr
 }
 
-func exitWithStatus(err error) {
+// exitWithStatus exits with the same status as the command that returned
+// err (or 128+N if it was killed by signal N). If the command ran in the
+// cgroup cg, it reports any processes that the OOM killer killed for
+// exceeding the memory limit and removes the cgroup.
+func exitWithStatus(err error, cg *cgroup) {
+	if cg != nil {
+		if n, err := cg.oomKills(); err != nil {
+			log.Println("Cannot check for OOM kills:", err)
+		} else if n > 0 {
+			log.Printf("Out of memory: the OOM killer killed %d process(es) in the sandbox for exceeding the memory limit", n)
+		}
+		if err := cg.remove(); err != nil {
+			log.Println("Cannot remove cgroup:", err)
+		}
+	}
 	if err == nil {
 		os.Exit(0)
 	}
@@ -163,5 +471,8 @@ func exitWithStatus(err error) {
 	if !ok {
 		log.Fatal(err)
 	}
+	if ws.Signaled() {
+		os.Exit(128 + int(ws.Signal()))
+	}
 	os.Exit(ws.ExitStatus())
 }
@@ -0,0 +1,644 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"debug/elf"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// The test binary doubles as namespacify (when NAMESPACIFY_TEST_MAIN or
+// reexecEnv is set) and as the commands that run inside the sandbox (when
+// NAMESPACIFY_TEST_HELPER is set). The sandboxed command is /proc/self/exe
+// so that it needn't be inside the chroot.
+
+func TestMain(m *testing.M) {
+	if os.Getenv("NAMESPACIFY_TEST_MAIN") != "" {
+		os.Unsetenv("NAMESPACIFY_TEST_MAIN")
+		main()
+		os.Exit(0)
+	}
+	if os.Getenv(reexecEnv) != "" {
+		main()
+	}
+	if name := os.Getenv("NAMESPACIFY_TEST_HELPER"); name != "" {
+		if err := helpers[name](); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+var helpers = map[string]func() error{
+	"true": func() error { return nil },
+	// listen checks that it can listen on (and connect to) the loopback
+	// interface.
+	"listen": func() error {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return err
+		}
+		defer ln.Close()
+		conn, err := net.DialTimeout("tcp", ln.Addr().String(), time.Second)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	},
+	// dev checks the minimal /dev and the /tmp tmpfs (of size 1m).
+	"dev": func() error {
+		entries, err := os.ReadDir("/dev")
+		if err != nil {
+			return err
+		}
+		var names []string
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+		want := []string{"fd", "full", "null", "ptmx", "pts", "random", "shm", "stderr", "stdin", "stdout", "tty", "urandom", "zero"}
+		if !slices.Equal(names, want) {
+			return fmt.Errorf("/dev has %q; want %q", names, want)
+		}
+		if err := os.WriteFile("/dev/null", []byte("x"), 0o666); err != nil {
+			return err
+		}
+		f, err := os.Open("/dev/zero")
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		b := []byte{1}
+		if _, err := f.Read(b); err != nil || b[0] != 0 {
+			return fmt.Errorf("bad read from /dev/zero: %v, %v", b, err)
+		}
+		if _, err := os.Stat("/dev/pts/ptmx"); err != nil {
+			return err
+		}
+		for _, dir := range []string{"/dev/shm", "/tmp"} {
+			if err := os.WriteFile(filepath.Join(dir, "x"), []byte("x"), 0o666); err != nil {
+				return err
+			}
+		}
+		var st syscall.Statfs_t
+		if err := syscall.Statfs("/tmp", &st); err != nil {
+			return err
+		}
+		if size := st.Blocks * uint64(st.Bsize); size != 1<<20 {
+			return fmt.Errorf("/tmp has size %d; want %d", size, 1<<20)
+		}
+		return nil
+	},
+	// write writes a file named by NAMESPACIFY_TEST_PATH.
+	"write": func() error {
+		return os.WriteFile(os.Getenv("NAMESPACIFY_TEST_PATH"), []byte("x"), 0o644)
+	},
+	// spec checks the settings from the spec in TestSpecFile.
+	"spec": func() error {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return err
+		}
+		wd, err := os.Getwd()
+		if err != nil {
+			return err
+		}
+		if hostname != "sandbox" || wd != "/work" || os.Getenv("FOO") != "bar" {
+			return fmt.Errorf("got hostname %q, working dir %q, FOO=%q", hostname, wd, os.Getenv("FOO"))
+		}
+		return nil
+	},
+	// rootfs checks that the root is the read-only one from TestRootfs.
+	"rootfs": func() error {
+		b, err := os.ReadFile("/etc/os-release")
+		if err != nil {
+			return err
+		}
+		if string(b) != "NAME=pinned\n" {
+			return fmt.Errorf("/etc/os-release contains %q", b)
+		}
+		if _, err := os.Stat("/usr/bin"); err == nil {
+			return errors.New("/usr/bin exists")
+		}
+		if err := os.WriteFile("/x", nil, 0o644); !errors.Is(err, syscall.EROFS) {
+			return fmt.Errorf("writing /x: got err %v; want EROFS", err)
+		}
+		return os.WriteFile("/tmp/x", nil, 0o644)
+	},
+	// overlay changes the files in the /work overlay from TestOverlay.
+	"overlay": func() error {
+		if err := os.WriteFile("/work/new", []byte("new"), 0o644); err != nil {
+			return err
+		}
+		if err := os.WriteFile("/work/mod", []byte("modified"), 0o644); err != nil {
+			return err
+		}
+		return os.Remove("/work/del")
+	},
+	// rlimit checks the nofile rlimit from TestRlimit.
+	"rlimit": func() error {
+		var lim syscall.Rlimit
+		if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &lim); err != nil {
+			return err
+		}
+		if lim.Cur != 100 || lim.Max != 100 {
+			return fmt.Errorf("got nofile rlimit %+v; want 100", lim)
+		}
+		return nil
+	},
+	// alloc allocates (and touches) 256 MB of memory.
+	"alloc": func() error {
+		b := make([]byte, 256<<20)
+		for i := range b {
+			b[i] = 1
+		}
+		return nil
+	},
+	// seccomp checks that the syscalls denied in TestSeccomp fail with
+	// EPERM.
+	"seccomp": func() error {
+		var uts syscall.Utsname
+		if err := syscall.Uname(&uts); err != syscall.EPERM {
+			return fmt.Errorf("uname: got err %v; want EPERM", err)
+		}
+		if err := syscall.Unshare(syscall.CLONE_NEWUTS); err != syscall.EPERM {
+			return fmt.Errorf("unshare: got err %v; want EPERM", err)
+		}
+		if err := syscall.Mount("none", "/tmp", "tmpfs", 0, ""); err != syscall.EPERM {
+			return fmt.Errorf("mount: got err %v; want EPERM", err)
+		}
+		// Namespaces can't be created with clone either (and clone3,
+		// whose flags the filter can't see, isn't available).
+		cmd := exec.Command("/proc/self/exe", "-test.run=^$")
+		cmd.SysProcAttr = &syscall.SysProcAttr{Cloneflags: syscall.CLONE_NEWUSER}
+		if err := cmd.Run(); !errors.Is(err, syscall.EPERM) {
+			return fmt.Errorf("clone(CLONE_NEWUSER): got err %v; want EPERM", err)
+		}
+		if _, _, errno := syscall.Syscall(unix.SYS_CLONE3, 0, 0, 0); errno != syscall.ENOSYS {
+			return fmt.Errorf("clone3: got err %v; want ENOSYS", errno)
+		}
+		_, err := os.Getwd()
+		return err
+	},
+	// subids checks that the sandbox has more than one uid and gid.
+	"subids": func() error {
+		for _, name := range []string{"/proc/self/uid_map", "/proc/self/gid_map"} {
+			b, err := os.ReadFile(name)
+			if err != nil {
+				return err
+			}
+			if n := strings.Count(string(b), "\n"); n < 2 {
+				return fmt.Errorf("%s has %d mapping(s):\n%s", name, n, b)
+			}
+		}
+		if err := os.WriteFile("/tmp/x", nil, 0o644); err != nil {
+			return err
+		}
+		return os.Chown("/tmp/x", 1, 1)
+	},
+	// wait writes /tmp/marker for TestExec and waits for stdin to close.
+	"wait": func() error {
+		if err := os.WriteFile("/tmp/marker", []byte("x"), 0o644); err != nil {
+			return err
+		}
+		_, err := io.Copy(io.Discard, os.Stdin)
+		return err
+	},
+	// joined checks that namespacify exec joined the sandbox from
+	// TestExec.
+	"joined": func() error {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return err
+		}
+		if hostname != "exec-test" {
+			return fmt.Errorf("got hostname %q", hostname)
+		}
+		if _, err := os.Stat("/tmp/marker"); err != nil {
+			return err
+		}
+		// The sandbox's init process is PID 1 in our PID namespace.
+		same, err := sameFile("/proc/1/ns/pid", "/proc/self/ns/pid")
+		if err != nil {
+			return err
+		}
+		if !same {
+			return errors.New("not in the sandbox's PID namespace")
+		}
+		return nil
+	},
+	// dial connects to NAMESPACIFY_TEST_ADDR.
+	"dial": func() error {
+		conn, err := net.DialTimeout("tcp", os.Getenv("NAMESPACIFY_TEST_ADDR"), time.Second)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	},
+}
+
+// sandbox returns a command that runs the named helper in a sandbox
+// created with the given namespacify flags.
+func sandbox(t *testing.T, helper string, flags ...string) *exec.Cmd {
+	t.Helper()
+	cmd := namespacify(t, append(flags, "/proc/self/exe")...)
+	cmd.Env = append(cmd.Env, "NAMESPACIFY_TEST_HELPER="+helper)
+	return cmd
+}
+
+// namespacify returns a command that runs namespacify with the given
+// arguments (and a temporary chroot dir).
+func namespacify(t *testing.T, args ...string) *exec.Cmd {
+	t.Helper()
+	args = append([]string{"-dir", filepath.Join(t.TempDir(), "chroot")}, args...)
+	return subcommand(t, args...)
+}
+
+// subcommand returns a command that runs namespacify with exactly the
+// given arguments (such as ls).
+func subcommand(t *testing.T, args ...string) *exec.Cmd {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Env = append(os.Environ(), "NAMESPACIFY_TEST_MAIN=1")
+	return cmd
+}
+
+// checkSandbox skips the test if namespacify can't create its namespaces
+// on this system.
+func checkSandbox(t *testing.T, flags ...string) {
+	t.Helper()
+	if out, err := sandbox(t, "true", flags...).CombinedOutput(); err != nil {
+		t.Skipf("cannot create sandbox: %s\n%s", err, out)
+	}
+}
+
+func TestNetNone(t *testing.T) {
+	checkSandbox(t, "-net=none")
+
+	if out, err := sandbox(t, "listen", "-net=none").CombinedOutput(); err != nil {
+		t.Errorf("cannot use loopback in sandbox: %s\n%s", err, out)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	addr := "NAMESPACIFY_TEST_ADDR=" + ln.Addr().String()
+
+	cmd := sandbox(t, "dial", "-net=none")
+	cmd.Env = append(cmd.Env, addr)
+	if err := cmd.Run(); err == nil {
+		t.Error("sandbox with -net=none connected to host listener")
+	}
+
+	cmd = sandbox(t, "dial", "-net=host")
+	cmd.Env = append(cmd.Env, addr)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Errorf("sandbox with -net=host cannot connect to host listener: %s\n%s", err, out)
+	}
+}
+
+func TestProc(t *testing.T) {
+	checkSandbox(t)
+
+	out, err := namespacify(t, "ps", "-e", "-o", "pid=").CombinedOutput()
+	if err != nil {
+		t.Fatalf("ps failed: %s\n%s", err, out)
+	}
+	// The processes are namespacify (as PID 1) and ps.
+	if pids := strings.Fields(string(out)); len(pids) != 2 || pids[0] != "1" {
+		t.Errorf("ps in sandbox lists PIDs %q; want 1 and one other", pids)
+	}
+
+	// With -proc=false, the host's /proc is bound.
+	out, err = namespacify(t, "-proc=false", "ps", "-e", "-o", "pid=").CombinedOutput()
+	if err != nil {
+		t.Fatalf("ps failed: %s\n%s", err, out)
+	}
+	if pids := strings.Fields(string(out)); !slices.Contains(pids, strconv.Itoa(os.Getpid())) {
+		t.Errorf("ps with -proc=false does not list the host test process")
+	}
+}
+
+func TestDevAndTmp(t *testing.T) {
+	checkSandbox(t)
+
+	if out, err := sandbox(t, "dev", "-dev", "-tmp=1m").CombinedOutput(); err != nil {
+		t.Errorf("bad /dev or /tmp: %s\n%s", err, out)
+	}
+}
+
+func TestBind(t *testing.T) {
+	checkSandbox(t)
+
+	dir := t.TempDir()
+	for _, tt := range []struct {
+		bind      string
+		path      string
+		wantWrite bool
+	}{
+		{dir + ":/work", "/work/a", false},
+		{dir + ":/work:ro", "/work/b", false},
+		{dir + ":/work:rw", "/work/c", true},
+		{dir + ":/x/y/z:rw", "/x/y/z/d", true},
+	} {
+		cmd := sandbox(t, "write", "-bind", tt.bind)
+		cmd.Env = append(cmd.Env, "NAMESPACIFY_TEST_PATH="+tt.path)
+		out, err := cmd.CombinedOutput()
+		if tt.wantWrite && err != nil {
+			t.Errorf("-bind %s: cannot write %s: %s\n%s", tt.bind, tt.path, err, out)
+		}
+		if !tt.wantWrite && err == nil {
+			t.Errorf("-bind %s: wrote %s", tt.bind, tt.path)
+		}
+		_, err = os.Stat(filepath.Join(dir, filepath.Base(tt.path)))
+		if wrote := err == nil; wrote != tt.wantWrite {
+			t.Errorf("-bind %s: file exists on host: %t", tt.bind, wrote)
+		}
+	}
+}
+
+func TestSpecFile(t *testing.T) {
+	checkSandbox(t)
+
+	name := filepath.Join(t.TempDir(), "spec.json")
+	js := fmt.Sprintf(`{
+	"mounts": [
+		{"source": "/usr", "target": "/usr"},
+		{"source": "/lib", "target": "/lib"},
+		{"source": "/lib64", "target": "/lib64"},
+		{"source": %q, "target": "/work"}
+	],
+	"env": ["FOO=bar"],
+	"hostname": "sandbox",
+	"workDir": "/work"
+}`, t.TempDir())
+	if err := os.WriteFile(name, []byte(js), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := sandbox(t, "spec", "-spec", name).CombinedOutput(); err != nil {
+		t.Errorf("sandbox does not match spec: %s\n%s", err, out)
+	}
+
+	out, err := sandbox(t, "true", "-spec", name, "-net=none").CombinedOutput()
+	if err == nil || !strings.Contains(string(out), "Cannot use -net with -spec") {
+		t.Errorf("using -net with -spec: got err %v, output:\n%s", err, out)
+	}
+}
+
+func TestOverlay(t *testing.T) {
+	checkSandbox(t, "-overlay")
+
+	dir := t.TempDir()
+	for _, name := range []string{"mod", "del"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	keep := t.TempDir()
+	for _, flags := range [][]string{
+		{"-overlay"},
+		{"-overlay", "-keep", keep},
+	} {
+		cmd := sandbox(t, "overlay", append(flags, "-bind", dir+":/work")...)
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Errorf("%q: cannot write to overlay: %s\n%s", flags, err, out)
+			continue
+		}
+		for _, want := range []string{"  A /work/new\n", "  M /work/mod\n", "  D /work/del\n"} {
+			if !strings.Contains(string(out), want) {
+				t.Errorf("%q: summary does not contain %q:\n%s", flags, want, out)
+			}
+		}
+		for _, name := range []string{"mod", "del"} {
+			b, err := os.ReadFile(filepath.Join(dir, name))
+			if err != nil || string(b) != name {
+				t.Errorf("%q: host file %s changed: %q, %v", flags, name, b, err)
+			}
+		}
+		if _, err := os.Stat(filepath.Join(dir, "new")); err == nil {
+			t.Errorf("%q: new file exists on host", flags)
+		}
+	}
+	b, err := os.ReadFile(filepath.Join(keep, "%2Fwork", "upper", "new"))
+	if err != nil || string(b) != "new" {
+		t.Errorf("-keep dir does not hold the new file: %q, %v", b, err)
+	}
+}
+
+func TestRlimit(t *testing.T) {
+	checkSandbox(t)
+	if out, err := sandbox(t, "rlimit", "-rlimit", "nofile=100").CombinedOutput(); err != nil {
+		t.Errorf("rlimit not set: %s\n%s", err, out)
+	}
+}
+
+func TestMemoryLimit(t *testing.T) {
+	checkSandbox(t)
+	out, err := sandbox(t, "alloc", "-memory", "32m").CombinedOutput()
+	if strings.Contains(string(out), "limits are not enforced") {
+		t.Skipf("cannot use cgroups:\n%s", out)
+	}
+	if err == nil {
+		t.Fatal("allocated 256 MB with a 32 MB memory limit")
+	}
+	if code := err.(*exec.ExitError).ExitCode(); code != 128+int(syscall.SIGKILL) {
+		t.Errorf("got exit code %d; want %d", code, 128+int(syscall.SIGKILL))
+	}
+	if !strings.Contains(string(out), "OOM killer killed") {
+		t.Errorf("OOM kill not reported:\n%s", out)
+	}
+}
+
+func TestSeccomp(t *testing.T) {
+	checkSandbox(t, "-seccomp", "default")
+
+	name := filepath.Join(t.TempDir(), "seccomp.json")
+	if err := os.WriteFile(name, []byte(`{"deny": ["uname"]}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := sandbox(t, "seccomp", "-seccomp", name).CombinedOutput(); err != nil {
+		t.Errorf("syscalls not denied: %s\n%s", err, out)
+	}
+	// Without the filter, the helper's syscalls succeed.
+	if err := sandbox(t, "seccomp").Run(); err == nil {
+		t.Error("syscalls denied without -seccomp")
+	}
+}
+
+func TestSubIDs(t *testing.T) {
+	checkSandbox(t)
+	for _, m := range []struct{ helper, file string }{
+		{"newuidmap", "/etc/subuid"},
+		{"newgidmap", "/etc/subgid"},
+	} {
+		if mapper, err := subIDMapper(m.helper, m.file, nil); err != nil || mapper == nil {
+			t.Skipf("no subordinate ID ranges in %s (or no %s): %v", m.file, m.helper, err)
+		}
+	}
+	if out, err := sandbox(t, "subids").CombinedOutput(); err != nil {
+		t.Errorf("subordinate IDs not mapped: %s\n%s", err, out)
+	}
+	if err := sandbox(t, "subids", "-subids=false").Run(); err == nil {
+		t.Error("subordinate IDs mapped with -subids=false")
+	}
+}
+
+func TestExec(t *testing.T) {
+	checkSandbox(t)
+
+	runtimeDir := t.TempDir()
+	withState := func(cmd *exec.Cmd) *exec.Cmd {
+		cmd.Env = append(cmd.Env, "XDG_RUNTIME_DIR="+runtimeDir)
+		return cmd
+	}
+	box := withState(sandbox(t, "wait", "-name", "exec-test", "-tmp=1m"))
+	stdin, err := box.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var boxOut bytes.Buffer
+	box.Stdout = &boxOut
+	box.Stderr = &boxOut
+	if err := box.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		stdin.Close()
+		box.Wait()
+	}()
+
+	// Wait for the sandbox to be recorded and set up.
+	for i := 0; ; i++ {
+		out, err := withState(subcommand(t, "ls")).CombinedOutput()
+		if err != nil {
+			t.Fatalf("ls failed: %s\n%s", err, out)
+		}
+		if strings.Contains(string(out), "exec-test") {
+			err := withState(subcommand(t, "exec", "exec-test", "--", "/bin/test", "-e", "/tmp/marker")).Run()
+			if err == nil {
+				break
+			}
+		}
+		if i == 100 {
+			t.Fatalf("sandbox not ready; ls output:\n%s\nsandbox output:\n%s", out, boxOut.Bytes())
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	cmd := withState(subcommand(t, "exec", "exec-test", "--", "/proc/self/exe"))
+	cmd.Env = append(cmd.Env, "NAMESPACIFY_TEST_HELPER=joined")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Errorf("exec did not join the sandbox: %s\n%s", err, out)
+	}
+	err = withState(subcommand(t, "exec", "exec-test", "sh", "-c", "exit 3")).Run()
+	if e, ok := err.(*exec.ExitError); !ok || e.ExitCode() != 3 {
+		t.Errorf("exec sh -c 'exit 3': got err %v; want exit status 3", err)
+	}
+	out, err := withState(subcommand(t, "exec", "nosuchsandbox", "true")).CombinedOutput()
+	if err == nil || !strings.Contains(string(out), "no sandbox named nosuchsandbox is running") {
+		t.Errorf("exec in missing sandbox: got err %v, output:\n%s", err, out)
+	}
+
+	if out, err := withState(subcommand(t, "kill", "exec-test")).CombinedOutput(); err != nil {
+		t.Fatalf("kill failed: %s\n%s", err, out)
+	}
+	if err := box.Wait(); err == nil {
+		t.Error("sandbox exited successfully after kill")
+	}
+	out, err = withState(subcommand(t, "ls")).CombinedOutput()
+	if err != nil || strings.Contains(string(out), "exec-test") {
+		t.Errorf("ls after kill: got err %v, output:\n%s", err, out)
+	}
+}
+
+func TestRootfs(t *testing.T) {
+	checkSandbox(t)
+
+	// The rootfs holds just enough to run the (dynamically linked) test
+	// binary.
+	entries := []tarEntry{
+		{hdr: tar.Header{Name: "etc/os-release", Typeflag: tar.TypeReg, Mode: 0o644}, body: "NAME=pinned\n"},
+	}
+	for _, name := range sharedLibs(t, os.Args[0]) {
+		b, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hdr := tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0o755}
+		entries = append(entries, tarEntry{hdr: hdr, body: string(b)})
+	}
+	tarName := filepath.Join(t.TempDir(), "image.tar.gz")
+	if err := os.WriteFile(tarName, gzipBytes(t, makeTar(t, entries)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := sandbox(t, "rootfs", "-rootfs", tarName, "-tmp=1m")
+	cmd.Env = append(cmd.Env, "XDG_CACHE_HOME="+tempDir(t))
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Errorf("bad rootfs: %s\n%s", err, out)
+	}
+}
+
+// sharedLibs lists the dynamic linker and shared libraries that the
+// executable exe needs.
+func sharedLibs(t *testing.T, exe string) []string {
+	t.Helper()
+	f, err := elf.Open(exe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var libs []string
+	for _, p := range f.Progs {
+		if p.Type == elf.PT_INTERP {
+			b := make([]byte, p.Filesz)
+			if _, err := p.ReadAt(b, 0); err != nil {
+				t.Fatal(err)
+			}
+			libs = append(libs, strings.TrimRight(string(b), "\x00"))
+		}
+	}
+	imported, err := f.ImportedLibraries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range imported {
+		var found []string
+		for _, pattern := range []string{"/lib*/", "/lib/*/", "/usr/lib*/", "/usr/lib/*/"} {
+			matches, _ := filepath.Glob(pattern + name)
+			found = append(found, matches...)
+		}
+		if len(found) == 0 {
+			t.Skipf("cannot find shared library %s", name)
+		}
+		libs = append(libs, found[0])
+	}
+	return libs
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// setLinkUp brings up the named network interface (in the current network
+// namespace) by sending an RTM_NEWLINK request over a netlink socket.
+func setLinkUp(name string) error {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return err
+	}
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_ROUTE)
+	if err != nil {
+		return os.NewSyscallError("socket", err)
+	}
+	defer syscall.Close(fd)
+	if err := syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		return os.NewSyscallError("bind", err)
+	}
+
+	const seq = 1
+	hdr := syscall.NlMsghdr{
+		Len:   syscall.NLMSG_HDRLEN + syscall.SizeofIfInfomsg,
+		Type:  syscall.RTM_NEWLINK,
+		Flags: syscall.NLM_F_REQUEST | syscall.NLM_F_ACK,
+		Seq:   seq,
+	}
+	info := syscall.IfInfomsg{
+		Family: syscall.AF_UNSPEC,
+		Index:  int32(iface.Index),
+		Flags:  syscall.IFF_UP,
+		Change: syscall.IFF_UP,
+	}
+	req := make([]byte, hdr.Len)
+	*(*syscall.NlMsghdr)(unsafe.Pointer(&req[0])) = hdr
+	*(*syscall.IfInfomsg)(unsafe.Pointer(&req[syscall.NLMSG_HDRLEN])) = info
+	if err := syscall.Sendto(fd, req, 0, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		return os.NewSyscallError("sendto", err)
+	}
+
+	// Wait for the ack (an NLMSG_ERROR message with an error code of 0).
+	buf := make([]byte, os.Getpagesize())
+	for {
+		n, _, err := syscall.Recvfrom(fd, buf, 0)
+		if err != nil {
+			return os.NewSyscallError("recvfrom", err)
+		}
+		msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			return err
+		}
+		for _, m := range msgs {
+			if m.Header.Seq != seq || m.Header.Type != syscall.NLMSG_ERROR {
+				continue
+			}
+			if len(m.Data) < 4 {
+				return fmt.Errorf("short netlink error message")
+			}
+			if errno := int32(binary.NativeEndian.Uint32(m.Data)); errno != 0 {
+				return fmt.Errorf("cannot bring up %s: %w", name, syscall.Errno(-errno))
+			}
+			return nil
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// overlays makes read-only directories in the sandbox writable by
+// mounting overlayfs layers on top of them. The upper (and work) dirs of
+// all the layers are kept in one directory, which is either a tmpfs that
+// disappears with the sandbox or a directory given with -keep.
+type overlays struct {
+	dir    string
+	layers []*overlayLayer
+	// before records the files in the upper dirs before the command runs
+	// (such as copies and mount points) so they aren't counted as
+	// changes.
+	before map[string]fileState
+}
+
+// An overlayLayer is an overlayfs mounted at target in the sandbox. We
+// keep its upper and lower dirs open so that we can look at them after
+// pivot_root.
+type overlayLayer struct {
+	target string
+	upper  *os.File
+	lower  *os.File
+}
+
+type fileState struct {
+	mode  fs.FileMode
+	size  int64
+	mtime time.Time
+}
+
+// newOverlays prepares to mount overlays with their upper dirs in keep,
+// or (if keep is empty) in a tmpfs mounted at dir.
+func newOverlays(dir, keep string) (*overlays, error) {
+	if keep != "" {
+		return &overlays{dir: keep}, nil
+	}
+	if err := mkdir(dir); err != nil {
+		return nil, err
+	}
+	if err := mount("tmpfs", dir, "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV, "mode=0700"); err != nil {
+		return nil, err
+	}
+	return &overlays{dir: dir}, nil
+}
+
+// mount mounts an overlay at mountPoint (which is target in the sandbox)
+// with lower as its lower dir.
+func (o *overlays) mount(lower, target, mountPoint string) error {
+	d := filepath.Join(o.dir, url.PathEscape(target))
+	upper := filepath.Join(d, "upper")
+	work := filepath.Join(d, "work")
+	for _, dir := range []string{upper, work} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	opts := fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s", lower, upper, work)
+	if err := mount("overlay", mountPoint, "overlay", 0, opts); err != nil {
+		os.RemoveAll(d)
+		return err
+	}
+	l := &overlayLayer{target: target}
+	var err error
+	if l.upper, err = os.Open(upper); err != nil {
+		return err
+	}
+	if l.lower, err = os.Open(lower); err != nil {
+		return err
+	}
+	o.layers = append(o.layers, l)
+	return nil
+}
+
+// fdPath gives a path that refers to the open directory f, even after
+// pivot_root has made its real path unreachable.
+func fdPath(f *os.File) string {
+	return fmt.Sprintf("/proc/self/fd/%d", f.Fd())
+}
+
+// walkUpper calls fn for every file in the upper dirs, passing the layer,
+// the file's path relative to the layer, and the file's info.
+func (o *overlays) walkUpper(fn func(l *overlayLayer, rel string, fi fs.FileInfo)) error {
+	for _, l := range o.layers {
+		err := fs.WalkDir(os.DirFS(fdPath(l.upper)), ".", func(rel string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if rel == "." {
+				return nil
+			}
+			fi, err := d.Info()
+			if err != nil {
+				return err
+			}
+			fn(l, rel, fi)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// snapshot records the current contents of the upper dirs.
+func (o *overlays) snapshot() error {
+	o.before = make(map[string]fileState)
+	return o.walkUpper(func(l *overlayLayer, rel string, fi fs.FileInfo) {
+		o.before[path.Join(l.target, rel)] = fileState{fi.Mode(), fi.Size(), fi.ModTime()}
+	})
+}
+
+// An overlayChange is a file that was added (A), modified (M), or deleted
+// (D) in an overlay.
+type overlayChange struct {
+	kind byte
+	path string
+}
+
+// changes lists the files that changed in the upper dirs since snapshot.
+func (o *overlays) changes() ([]overlayChange, error) {
+	var changes []overlayChange
+	err := o.walkUpper(func(l *overlayLayer, rel string, fi fs.FileInfo) {
+		p := path.Join(l.target, rel)
+		if st, ok := o.before[p]; ok && (fi.IsDir() || st == fileState{fi.Mode(), fi.Size(), fi.ModTime()}) {
+			return
+		}
+		_, err := os.Lstat(filepath.Join(fdPath(l.lower), rel))
+		inLower := err == nil
+		switch {
+		case isWhiteout(fi):
+			changes = append(changes, overlayChange{'D', p})
+		case !inLower:
+			changes = append(changes, overlayChange{'A', p})
+		case !fi.IsDir():
+			// Directories are copied up to the upper dir when their
+			// contents change, so only files count as modified.
+			changes = append(changes, overlayChange{'M', p})
+		}
+	})
+	slices.SortFunc(changes, func(a, b overlayChange) int { return strings.Compare(a.path, b.path) })
+	return changes, err
+}
+
+// isWhiteout reports whether fi is an overlayfs whiteout (which marks a
+// deleted file): a character device with device number 0/0.
+func isWhiteout(fi fs.FileInfo) bool {
+	if fi.Mode()&fs.ModeCharDevice == 0 {
+		return false
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	return ok && st.Rdev == 0
+}
+
+// printSummary prints the changes in the overlays. If keep is not empty,
+// it says that the changes were kept there.
+func (o *overlays) printSummary(w io.Writer, keep string) error {
+	changes, err := o.changes()
+	if err != nil {
+		return err
+	}
+	where := "discarded"
+	if keep != "" {
+		where = "kept in " + keep
+	}
+	if len(changes) == 0 {
+		fmt.Fprintf(w, "namespacify: no files changed in the overlay (%s)\n", where)
+		return nil
+	}
+	files := "files"
+	if len(changes) == 1 {
+		files = "file"
+	}
+	fmt.Fprintf(w, "namespacify: %d %s changed in the overlay (%s):\n", len(changes), files, where)
+	for _, c := range changes {
+		fmt.Fprintf(w, "  %c %s\n", c.kind, c.path)
+	}
+	return nil
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// rootfsDir returns a directory holding the extracted contents of the
+// tarball (optionally gzipped) at name. Extracted tarballs are cached in
+// the user's cache directory, keyed by the SHA-256 of the tarball.
+func rootfsDir(name string) (string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	cacheDir = filepath.Join(cacheDir, "namespacify", "rootfs")
+	dir := filepath.Join(cacheDir, hex.EncodeToString(h.Sum(nil)))
+	if _, err := os.Stat(dir); err == nil {
+		return dir, nil
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(cacheDir, 0755); err != nil {
+		return "", err
+	}
+	tmp, err := os.MkdirTemp(cacheDir, "tmp-")
+	if err != nil {
+		return "", err
+	}
+	if err := extractTar(tmp, f); err != nil {
+		os.RemoveAll(tmp)
+		return "", fmt.Errorf("cannot extract %s: %s", name, err)
+	}
+	if err := os.Rename(tmp, dir); err != nil {
+		os.RemoveAll(tmp)
+		// Another namespacify may have extracted the same tarball
+		// concurrently.
+		if _, err := os.Stat(dir); err == nil {
+			return dir, nil
+		}
+		return "", err
+	}
+	return dir, nil
+}
+
+// rootfsMountPoints are created in every extracted rootfs, since the
+// rootfs is mounted read-only.
+var rootfsMountPoints = []string{"dev", "proc", "sys", "tmp"}
+
+const (
+	whiteoutPrefix = ".wh."
+	opaqueWhiteout = ".wh..wh..opq"
+)
+
+// extractTar extracts the tarball read from r into dir.
+//
+// We're not privileged, so file ownership is not preserved and device
+// nodes and FIFOs are skipped. Whiteout files (which remove files in lower
+// layers of a container image) delete the file that they name and are not
+// themselves extracted.
+func extractTar(dir string, r io.Reader) error {
+	br := bufio.NewReader(r)
+	r = br
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		r = zr
+	}
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+
+	// Directories are writable while we extract into them; they get
+	// their real modes at the end.
+	type dirMode struct {
+		name string
+		mode fs.FileMode
+	}
+	var dirModes []dirMode
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		name := cleanTarName(hdr.Name)
+		parent, base := path.Split(name)
+		if parent != "" {
+			if err := root.MkdirAll(parent, 0755); err != nil {
+				return err
+			}
+		}
+		if strings.HasPrefix(base, whiteoutPrefix) {
+			if base != opaqueWhiteout {
+				if err := root.RemoveAll(path.Join(parent, strings.TrimPrefix(base, whiteoutPrefix))); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		mode := hdr.FileInfo().Mode()
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if fi, err := root.Lstat(name); err == nil && !fi.IsDir() {
+				if err := root.Remove(name); err != nil {
+					return err
+				}
+			}
+			if err := root.Mkdir(name, 0755); err != nil && !errors.Is(err, fs.ErrExist) {
+				return err
+			}
+			dirModes = append(dirModes, dirMode{name, mode})
+		case tar.TypeReg:
+			if err := removeExisting(root, name); err != nil {
+				return err
+			}
+			f, err := root.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(f, tr)
+			if err1 := f.Close(); err == nil {
+				err = err1
+			}
+			if err != nil {
+				return err
+			}
+			if err := root.Chmod(name, mode); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			if err := removeExisting(root, name); err != nil {
+				return err
+			}
+			if err := root.Symlink(hdr.Linkname, name); err != nil {
+				return err
+			}
+		case tar.TypeLink:
+			if err := removeExisting(root, name); err != nil {
+				return err
+			}
+			if err := root.Link(cleanTarName(hdr.Linkname), name); err != nil {
+				return err
+			}
+		default:
+			// Device nodes, FIFOs, and anything else we don't know
+			// about.
+		}
+	}
+	for _, name := range rootfsMountPoints {
+		if err := root.MkdirAll(name, 0755); err != nil {
+			return err
+		}
+	}
+	for i := len(dirModes) - 1; i >= 0; i-- {
+		if err := root.Chmod(dirModes[i].name, dirModes[i].mode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkMountTargets checks that the targets of the bind mounts exist in
+// the rootfs at dir. (They can't be created since it is read-only.)
+func checkMountTargets(dir string, mounts []bindMount) error {
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+	for _, m := range mounts {
+		if _, err := root.Stat(cleanTarName(m.Target)); err != nil {
+			return fmt.Errorf("bind mount target %s does not exist in the rootfs", m.Target)
+		}
+	}
+	return nil
+}
+
+// cleanTarName converts the name of a file in a tarball to a path relative
+// to the root (or "." for the root itself). Leading ..s are dropped.
+func cleanTarName(name string) string {
+	name = path.Clean("/" + name)
+	if name == "/" {
+		return "."
+	}
+	return name[1:]
+}
+
+// removeExisting removes name (but not a directory) so that it can be
+// replaced by a later entry of a tarball with the same name.
+func removeExisting(root *os.Root, name string) error {
+	fi, err := root.Lstat(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if fi.IsDir() {
+		return fmt.Errorf("cannot replace directory %s", name)
+	}
+	return root.Remove(name)
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// A tarEntry is a file to put in a test tarball.
+type tarEntry struct {
+	hdr  tar.Header
+	body string
+}
+
+func makeTar(t *testing.T, entries []tarEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := e.hdr
+		hdr.Size = int64(len(e.body))
+		if err := tw.WriteHeader(&hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func gzipBytes(t *testing.T, b []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write(b)
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+var testTarEntries = []tarEntry{
+	{hdr: tar.Header{Name: "./", Typeflag: tar.TypeDir, Mode: 0o755}},
+	{hdr: tar.Header{Name: "etc/", Typeflag: tar.TypeDir, Mode: 0o555}},
+	{hdr: tar.Header{Name: "etc/os-release", Typeflag: tar.TypeReg, Mode: 0o644}, body: "NAME=pinned\n"},
+	{hdr: tar.Header{Name: "bin/tool", Typeflag: tar.TypeReg, Mode: 0o755}, body: "tool"},
+	{hdr: tar.Header{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "/etc/os-release"}},
+	{hdr: tar.Header{Name: "hard", Typeflag: tar.TypeLink, Linkname: "./etc/os-release"}},
+	{hdr: tar.Header{Name: "devices/sda", Typeflag: tar.TypeBlock, Mode: 0o660, Devmajor: 8}},
+	{hdr: tar.Header{Name: "gone", Typeflag: tar.TypeReg, Mode: 0o644}, body: "gone"},
+	{hdr: tar.Header{Name: ".wh.gone", Typeflag: tar.TypeReg}},
+	{hdr: tar.Header{Name: "opaque/.wh..wh..opq", Typeflag: tar.TypeReg}},
+	{hdr: tar.Header{Name: "../../escape", Typeflag: tar.TypeReg, Mode: 0o644}, body: "escape"},
+}
+
+// tempDir is like t.TempDir, but it can be cleaned up even if it holds
+// read-only directories.
+func tempDir(t *testing.T) string {
+	dir := t.TempDir()
+	t.Cleanup(func() {
+		filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err == nil && d.IsDir() {
+				os.Chmod(path, 0o755)
+			}
+			return nil
+		})
+	})
+	return dir
+}
+
+func TestExtractTar(t *testing.T) {
+	dir := filepath.Join(tempDir(t), "root")
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := extractTar(dir, bytes.NewReader(makeTar(t, testTarEntries))); err != nil {
+		t.Fatal(err)
+	}
+
+	checkFile := func(name, want string) {
+		t.Helper()
+		b, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if string(b) != want {
+			t.Errorf("%s contains %q; want %q", name, b, want)
+		}
+	}
+	checkFile("etc/os-release", "NAME=pinned\n")
+	checkFile("escape", "escape")
+	checkMode := func(name string, want os.FileMode) {
+		t.Helper()
+		fi, err := os.Lstat(filepath.Join(dir, name))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if fi.Mode() != want {
+			t.Errorf("%s has mode %s; want %s", name, fi.Mode(), want)
+		}
+	}
+	checkMode("etc", os.ModeDir|0o555)
+	checkMode("bin/tool", 0o755)
+	checkMode("etc/os-release", 0o644)
+	checkMode("opaque", os.ModeDir|0o755)
+	for _, name := range rootfsMountPoints {
+		checkMode(name, os.ModeDir|0o755)
+	}
+	if target, err := os.Readlink(filepath.Join(dir, "link")); err != nil || target != "/etc/os-release" {
+		t.Errorf("link: got target %q, err %v", target, err)
+	}
+	fi0, err := os.Stat(filepath.Join(dir, "etc/os-release"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fi1, err := os.Stat(filepath.Join(dir, "hard"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !os.SameFile(fi0, fi1) {
+		t.Error("hard is not a hard link to etc/os-release")
+	}
+	for _, name := range []string{"devices/sda", "gone", ".wh.gone", "opaque/.wh..wh..opq"} {
+		if _, err := os.Lstat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+			t.Errorf("%s exists (err: %v)", name, err)
+		}
+	}
+}
+
+func TestRootfsDirCache(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", tempDir(t))
+	tb := makeTar(t, testTarEntries)
+	tarName := filepath.Join(t.TempDir(), "image.tar")
+	if err := os.WriteFile(tarName, tb, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	gzName := filepath.Join(t.TempDir(), "image.tar.gz")
+	if err := os.WriteFile(gzName, gzipBytes(t, tb), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	dir0, err := rootfsDir(tarName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir1, err := rootfsDir(tarName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dir0 != dir1 {
+		t.Errorf("same tarball extracted to %s and %s", dir0, dir1)
+	}
+	dir2, err := rootfsDir(gzName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dir2 == dir0 {
+		t.Error("different tarballs extracted to the same dir")
+	}
+	b, err := os.ReadFile(filepath.Join(dir2, "etc/os-release"))
+	if err != nil || string(b) != "NAME=pinned\n" {
+		t.Errorf("bad os-release from gzipped tarball: %q, %v", b, err)
+	}
+	entries, err := os.ReadDir(filepath.Dir(dir0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("cache has %d entries; want 2", len(entries))
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+//go:generate go run mksyscalls.go
+
+// A seccompProfile says which syscalls the sandboxed command may make.
+// Denied syscalls fail with EPERM.
+type seccompProfile struct {
+	// Default is what happens to syscalls that aren't listed in Allow
+	// or Deny: "allow" (the default) or "deny".
+	Default string `json:"default"`
+	// Allow and Deny list syscalls by name. If Default is allow, the
+	// syscalls in defaultDeniedSyscalls are denied as well, unless they
+	// are listed in Allow.
+	//
+	// While unshare is denied that way, so is creating namespaces with
+	// clone, and clone3 fails with ENOSYS (its flags are in memory, where
+	// the filter can't check them, and libc falls back to clone).
+	Allow []string `json:"allow"`
+	Deny  []string `json:"deny"`
+}
+
+// defaultDeniedSyscalls are syscalls that sandboxed commands shouldn't
+// need and that expose a lot of kernel surface or get around the sandbox.
+// (The sandbox is fully set up, including its mounts, before the filter
+// is installed.) Names that don't exist on this architecture are ignored.
+var defaultDeniedSyscalls = []string{
+	"acct",
+	"add_key",
+	"bpf",
+	"clock_adjtime",
+	"clock_settime",
+	"create_module",
+	"delete_module",
+	"finit_module",
+	"fsconfig",
+	"fsmount",
+	"fsopen",
+	"fspick",
+	"get_kernel_syms",
+	"init_module",
+	"ioperm",
+	"iopl",
+	"kcmp",
+	"kexec_file_load",
+	"kexec_load",
+	"keyctl",
+	"lookup_dcookie",
+	"mount",
+	"mount_setattr",
+	"move_mount",
+	"name_to_handle_at",
+	"nfsservctl",
+	"open_by_handle_at",
+	"open_tree",
+	"perf_event_open",
+	"pivot_root",
+	"process_vm_readv",
+	"process_vm_writev",
+	"ptrace",
+	"query_module",
+	"quotactl",
+	"reboot",
+	"request_key",
+	"setns",
+	"settimeofday",
+	"swapoff",
+	"swapon",
+	"syslog",
+	"umount2",
+	"unshare",
+	"uselib",
+	"userfaultfd",
+}
+
+// loadSeccompProfile reads a JSON seccomp profile.
+func loadSeccompProfile(name string) (*seccompProfile, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var p seccompProfile
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&p); err != nil {
+		return nil, fmt.Errorf("bad seccomp profile %s: %s", name, err)
+	}
+	return &p, nil
+}
+
+// check checks that p names known syscalls on this architecture.
+func (p *seccompProfile) check() error {
+	if syscallNumbers == nil {
+		return errors.New("not supported on this architecture")
+	}
+	var errs []error
+	if p.Default != "" && p.Default != "allow" && p.Default != "deny" {
+		errs = append(errs, fmt.Errorf("default must be allow or deny (got %q)", p.Default))
+	}
+	for _, name := range slices.Concat(p.Allow, p.Deny) {
+		if _, ok := syscallNumbers[name]; !ok {
+			errs = append(errs, fmt.Errorf("unknown syscall %q", name))
+		}
+	}
+	for _, name := range p.Allow {
+		if slices.Contains(p.Deny, name) {
+			errs = append(errs, fmt.Errorf("syscall %q is both allowed and denied", name))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// retEPERM is the seccomp action that makes a syscall fail with EPERM.
+const retEPERM = unix.SECCOMP_RET_ERRNO | uint32(unix.EPERM)
+
+// cloneNewFlags are the clone flags that create namespaces.
+const cloneNewFlags = unix.CLONE_NEWNS | unix.CLONE_NEWCGROUP | unix.CLONE_NEWUTS |
+	unix.CLONE_NEWIPC | unix.CLONE_NEWUSER | unix.CLONE_NEWPID |
+	unix.CLONE_NEWNET | unix.CLONE_NEWTIME
+
+// filter compiles p to a BPF program for seccomp.
+//
+// The program checks the architecture (killing the process if it's
+// wrong, since the syscall numbers would mean something else), and then
+// compares the syscall number against each listed syscall in turn. If
+// unshare is denied by the deny-list, it then checks clone's flags and
+// makes clone3 fail with ENOSYS.
+func (p *seccompProfile) filter() []unix.SockFilter {
+	listed := make(map[uint32]bool)
+	listedAction := uint32(unix.SECCOMP_RET_ALLOW)
+	defaultAction := uint32(retEPERM)
+	denyNamespaces := false
+	if p.Default == "deny" {
+		for _, name := range p.Allow {
+			listed[syscallNumbers[name]] = true
+		}
+	} else {
+		listedAction, defaultAction = defaultAction, listedAction
+		for _, name := range slices.Concat(defaultDeniedSyscalls, p.Deny) {
+			if nr, ok := syscallNumbers[name]; ok && !slices.Contains(p.Allow, name) {
+				listed[nr] = true
+			}
+		}
+		denyNamespaces = listed[syscallNumbers["unshare"]]
+	}
+
+	const (
+		// Offsets into struct seccomp_data.
+		offNR   = 0
+		offArch = 4
+		// The low 32 bits of the first argument (on little-endian
+		// architectures), which hold all of clone's flags.
+		offArg0 = 16
+	)
+	prog := []unix.SockFilter{
+		bpfStmt(unix.BPF_LD|unix.BPF_W|unix.BPF_ABS, offArch),
+		bpfJump(unix.BPF_JMP|unix.BPF_JEQ|unix.BPF_K, auditArch, 1, 0),
+		bpfStmt(unix.BPF_RET|unix.BPF_K, unix.SECCOMP_RET_KILL_PROCESS),
+		bpfStmt(unix.BPF_LD|unix.BPF_W|unix.BPF_ABS, offNR),
+	}
+	if auditArch == unix.AUDIT_ARCH_X86_64 {
+		// x32 syscalls have the same architecture but different
+		// numbers (with this bit set). Deny them all.
+		const x32SyscallBit = 0x40000000
+		prog = append(prog,
+			bpfJump(unix.BPF_JMP|unix.BPF_JGE|unix.BPF_K, x32SyscallBit, 0, 1),
+			bpfStmt(unix.BPF_RET|unix.BPF_K, retEPERM),
+		)
+	}
+	for _, nr := range slices.Sorted(maps.Keys(listed)) {
+		prog = append(prog,
+			bpfJump(unix.BPF_JMP|unix.BPF_JEQ|unix.BPF_K, nr, 0, 1),
+			bpfStmt(unix.BPF_RET|unix.BPF_K, listedAction),
+		)
+	}
+	if denyNamespaces {
+		if nr, ok := syscallNumbers["clone3"]; ok {
+			prog = append(prog,
+				bpfJump(unix.BPF_JMP|unix.BPF_JEQ|unix.BPF_K, nr, 0, 1),
+				bpfStmt(unix.BPF_RET|unix.BPF_K, unix.SECCOMP_RET_ERRNO|uint32(unix.ENOSYS)),
+			)
+		}
+		prog = append(prog,
+			bpfJump(unix.BPF_JMP|unix.BPF_JEQ|unix.BPF_K, syscallNumbers["clone"], 0, 3),
+			bpfStmt(unix.BPF_LD|unix.BPF_W|unix.BPF_ABS, offArg0),
+			bpfJump(unix.BPF_JMP|unix.BPF_JSET|unix.BPF_K, cloneNewFlags, 0, 1),
+			bpfStmt(unix.BPF_RET|unix.BPF_K, retEPERM),
+		)
+	}
+	return append(prog, bpfStmt(unix.BPF_RET|unix.BPF_K, defaultAction))
+}
+
+func bpfStmt(code uint16, k uint32) unix.SockFilter {
+	return unix.SockFilter{Code: code, K: k}
+}
+
+func bpfJump(code uint16, k uint32, jt, jf uint8) unix.SockFilter {
+	return unix.SockFilter{Code: code, Jt: jt, Jf: jf, K: k}
+}
+
+// installSeccomp installs the filter for p in all the threads of the
+// current process. Any processes that it starts inherit the filter.
+func installSeccomp(p *seccompProfile) error {
+	// Without CAP_SYS_ADMIN (in the initial user namespace), installing
+	// a filter requires no_new_privs, which keeps setuid binaries from
+	// running with the filter.
+	if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+		return fmt.Errorf("cannot set no_new_privs: %s", err)
+	}
+	filter := p.filter()
+	prog := unix.SockFprog{Len: uint16(len(filter)), Filter: &filter[0]}
+	// The Go runtime has several threads, and we don't know which one
+	// will start the command, so the filter must apply to all of them
+	// (TSYNC).
+	tid, _, errno := unix.Syscall(unix.SYS_SECCOMP, unix.SECCOMP_SET_MODE_FILTER, unix.SECCOMP_FILTER_FLAG_TSYNC, uintptr(unsafe.Pointer(&prog)))
+	if errno != 0 {
+		return fmt.Errorf("cannot install seccomp filter: %s", errno)
+	}
+	if tid != 0 {
+		return fmt.Errorf("cannot install seccomp filter: thread %d cannot be synchronized", tid)
+	}
+	return nil
+}
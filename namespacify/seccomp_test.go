@@ -0,0 +1,162 @@
+package main
+
+import (
+	"slices"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestSeccompFilter(t *testing.T) {
+	if syscallNumbers == nil {
+		t.Skip("seccomp is not supported on this architecture")
+	}
+	for _, tt := range []struct {
+		name    string
+		p       *seccompProfile
+		allowed []string
+		denied  []string
+	}{
+		{
+			"default",
+			&seccompProfile{},
+			[]string{"read", "write", "uname"},
+			[]string{"mount", "ptrace", "bpf"},
+		},
+		{
+			"custom deny-list",
+			&seccompProfile{Allow: []string{"ptrace"}, Deny: []string{"uname"}},
+			[]string{"read", "ptrace"},
+			[]string{"mount", "uname"},
+		},
+		{
+			"allow-list",
+			&seccompProfile{Default: "deny", Allow: []string{"read", "write"}},
+			[]string{"read", "write"},
+			[]string{"uname", "openat"},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.p.check(); err != nil {
+				t.Fatal(err)
+			}
+			prog := tt.p.filter()
+			for _, name := range tt.allowed {
+				if got := runFilter(prog, syscallNumbers[name]); got != unix.SECCOMP_RET_ALLOW {
+					t.Errorf("%s: got action %#x; want allow", name, got)
+				}
+			}
+			for _, name := range tt.denied {
+				if got := runFilter(prog, syscallNumbers[name]); got != retEPERM {
+					t.Errorf("%s: got action %#x; want EPERM", name, got)
+				}
+			}
+		})
+	}
+}
+
+func TestSeccompFilterClone(t *testing.T) {
+	if syscallNumbers == nil {
+		t.Skip("seccomp is not supported on this architecture")
+	}
+	const enosys = unix.SECCOMP_RET_ERRNO | uint32(unix.ENOSYS)
+	clone, clone3 := syscallNumbers["clone"], syscallNumbers["clone3"]
+	thread := uint64(unix.CLONE_VM | unix.CLONE_FS | unix.CLONE_FILES | unix.CLONE_SIGHAND | unix.CLONE_THREAD)
+	for _, tt := range []struct {
+		name string
+		p    *seccompProfile
+		nr   uint32
+		arg0 uint64
+		want uint32
+	}{
+		{"default/fork", &seccompProfile{}, clone, uint64(unix.SIGCHLD), unix.SECCOMP_RET_ALLOW},
+		{"default/thread", &seccompProfile{}, clone, thread, unix.SECCOMP_RET_ALLOW},
+		{"default/newuser", &seccompProfile{}, clone, unix.CLONE_NEWUSER | uint64(unix.SIGCHLD), retEPERM},
+		{"default/newtime", &seccompProfile{}, clone, unix.CLONE_NEWTIME, retEPERM},
+		{"default/clone3", &seccompProfile{}, clone3, 0, enosys},
+		{"unshare allowed/newuser", &seccompProfile{Allow: []string{"unshare"}}, clone, unix.CLONE_NEWUSER, unix.SECCOMP_RET_ALLOW},
+		{"unshare allowed/clone3", &seccompProfile{Allow: []string{"unshare"}}, clone3, 0, unix.SECCOMP_RET_ALLOW},
+		{"clone denied", &seccompProfile{Deny: []string{"clone"}}, clone, uint64(unix.SIGCHLD), retEPERM},
+		{"allow-list", &seccompProfile{Default: "deny", Allow: []string{"clone", "clone3"}}, clone, unix.CLONE_NEWUSER, unix.SECCOMP_RET_ALLOW},
+	} {
+		if got := runFilter(tt.p.filter(), tt.nr, tt.arg0); got != tt.want {
+			t.Errorf("%s: got action %#x; want %#x", tt.name, got, tt.want)
+		}
+	}
+}
+
+// runFilter interprets the subset of BPF that filter generates for a
+// syscall nr with the given arguments on this architecture and returns
+// the action.
+func runFilter(prog []unix.SockFilter, nr uint32, args ...uint64) uint32 {
+	var a uint32
+	for pc := 0; pc < len(prog); pc++ {
+		ins := prog[pc]
+		switch ins.Code {
+		case unix.BPF_LD | unix.BPF_W | unix.BPF_ABS:
+			switch {
+			case ins.K == 0:
+				a = nr
+			case ins.K == 4:
+				a = auditArch
+			case ins.K >= 16:
+				// The arguments are 64 bits; the low half comes
+				// first on little-endian architectures.
+				var arg uint64
+				if i := int(ins.K-16) / 8; i < len(args) {
+					arg = args[i]
+				}
+				if ins.K%8 == 4 {
+					arg >>= 32
+				}
+				a = uint32(arg)
+			}
+		case unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K:
+			if a == ins.K {
+				pc += int(ins.Jt)
+			} else {
+				pc += int(ins.Jf)
+			}
+		case unix.BPF_JMP | unix.BPF_JGE | unix.BPF_K:
+			if a >= ins.K {
+				pc += int(ins.Jt)
+			} else {
+				pc += int(ins.Jf)
+			}
+		case unix.BPF_JMP | unix.BPF_JSET | unix.BPF_K:
+			if a&ins.K != 0 {
+				pc += int(ins.Jt)
+			} else {
+				pc += int(ins.Jf)
+			}
+		case unix.BPF_RET | unix.BPF_K:
+			return ins.K
+		default:
+			panic("unexpected instruction")
+		}
+	}
+	panic("fell off the end of the program")
+}
+
+func TestSeccompCheck(t *testing.T) {
+	if syscallNumbers == nil {
+		t.Skip("seccomp is not supported on this architecture")
+	}
+	p := &seccompProfile{Default: "log", Allow: []string{"read", "nosuchcall"}, Deny: []string{"read"}}
+	err := p.check()
+	if err == nil {
+		t.Fatal("got no error")
+	}
+	want := []string{
+		`default must be allow or deny (got "log")`,
+		`unknown syscall "nosuchcall"`,
+		`syscall "read" is both allowed and denied`,
+	}
+	var got []string
+	for _, e := range err.(interface{ Unwrap() []error }).Unwrap() {
+		got = append(got, e.Error())
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("got errors %q; want %q", got, want)
+	}
+}
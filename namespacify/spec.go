@@ -0,0 +1,397 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// A spec describes a sandbox. It is either loaded from a JSON file (with
+// -spec) or built from the command-line flags.
+type spec struct {
+	// Rootfs is a tarball (optionally gzipped) holding the sandbox's root
+	// filesystem, which is mounted read-only. If it is empty, the root
+	// is built from Mounts and Copies.
+	Rootfs string `json:"rootfs"`
+	// Overlay says to make the root and the read-only directory mounts
+	// writable by mounting overlays on them. Changes go to a tmpfs, which
+	// is discarded on exit, or to the directory Keep.
+	Overlay bool   `json:"overlay"`
+	Keep    string `json:"keep"`
+	// Net is "none" (a private network namespace with only loopback) or
+	// "host".
+	Net string `json:"net"`
+	// Proc says to mount a new /proc (rather than binding the host's).
+	// It is on by default: the sandbox always has its own PID namespace,
+	// and the host's /proc would list the host's processes in it.
+	Proc bool `json:"proc"`
+	// Dev says to create a minimal /dev on tmpfs (rather than binding
+	// the host's).
+	Dev bool `json:"dev"`
+	// TmpSize is the size limit of the tmpfs on /tmp. If it is empty,
+	// there is no /tmp.
+	TmpSize string `json:"tmpSize"`
+
+	// Memory, Pids, and CPU limit the sandbox's memory (bytes with an
+	// optional k, m, or g suffix), number of processes, and number of
+	// CPUs with a cgroup. Empty or zero values mean no limit.
+	Memory string  `json:"memory"`
+	Pids   int     `json:"pids"`
+	CPU    float64 `json:"cpu"`
+	// Rlimits maps resource names (see rlimitResources) to the value of
+	// both the soft and hard limits of the command.
+	Rlimits map[string]uint64 `json:"rlimits"`
+	// Seccomp, if set, is a profile of syscalls that the command may
+	// make. An empty profile denies defaultDeniedSyscalls.
+	Seccomp *seccompProfile `json:"seccomp"`
+
+	// Mounts and Copies default to the host's system directories and a
+	// few files from its /etc (unless there's a rootfs).
+	Mounts []bindMount `json:"mounts"`
+	Copies []fileCopy  `json:"copies"`
+
+	// Env lists KEY=VALUE pairs to add to the command's environment.
+	Env []string `json:"env"`
+	// Hostname is the sandbox's hostname. If it is empty, a random
+	// ns-<hex> name is used.
+	Hostname string `json:"hostname"`
+	// WorkDir is the command's working directory in the sandbox. If it
+	// is empty, the command runs in /.
+	WorkDir string `json:"workDir"`
+
+	UIDMap []idMap `json:"uidMap"`
+	GIDMap []idMap `json:"gidMap"`
+	// SubIDs says to add the user's subordinate ID ranges (from
+	// /etc/subuid and /etc/subgid) to the maps, if there are any and
+	// newuidmap and newgidmap are installed.
+	SubIDs bool `json:"subIDs"`
+}
+
+// A bindMount bind-mounts Source (a file or directory on the host) at
+// Target in the sandbox. It is read-only unless RW is set.
+type bindMount struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+	RW     bool   `json:"rw"`
+}
+
+// A fileCopy copies Source (a file or directory on the host) to Target in
+// the sandbox. If Target is empty, it is the same as Source.
+type fileCopy struct {
+	Source string `json:"source"`
+	Target string `json:"target,omitempty"`
+}
+
+// An idMap maps Size IDs starting at HostID on the host to IDs starting at
+// ContainerID in the sandbox.
+type idMap struct {
+	ContainerID int `json:"containerID"`
+	HostID      int `json:"hostID"`
+	Size        int `json:"size"`
+}
+
+// defaultSpec gives the sandbox that namespacify creates without any
+// flags, except for the default Mounts and Copies (see hostMounts and
+// hostCopies). A spec file overrides the fields that it sets.
+func defaultSpec() *spec {
+	return &spec{
+		Net:    "host",
+		Proc:   true,
+		UIDMap: []idMap{{ContainerID: 0, HostID: os.Getuid(), Size: 1}},
+		GIDMap: []idMap{{ContainerID: 0, HostID: os.Getgid(), Size: 1}},
+		SubIDs: true,
+	}
+}
+
+// hostMounts gives the default bind mounts of a sandbox without a rootfs.
+func hostMounts() []bindMount {
+	var mounts []bindMount
+	for _, dir := range []string{"/bin", "/lib", "/lib64", "/sbin", "/sys", "/usr"} {
+		mounts = append(mounts, bindMount{Source: dir, Target: dir})
+	}
+	return mounts
+}
+
+// hostCopies gives the default copies of a sandbox without a rootfs.
+func hostCopies() []fileCopy {
+	var copies []fileCopy
+	for _, p := range []string{"/etc/resolv.conf", "/etc/ssl/certs", "/etc/passwd"} {
+		copies = append(copies, fileCopy{Source: p})
+	}
+	return copies
+}
+
+// loadSpec reads a spec file. Fields that the file doesn't set keep their
+// values from defaultSpec (or hostMounts and hostCopies).
+func loadSpec(name string) (*spec, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	s := defaultSpec()
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(s); err != nil {
+		return nil, fmt.Errorf("bad spec file %s: %s", name, err)
+	}
+	if dec.More() {
+		return nil, fmt.Errorf("bad spec file %s: trailing data after spec", name)
+	}
+	if s.Rootfs == "" {
+		if s.Mounts == nil {
+			s.Mounts = hostMounts()
+		}
+		if s.Copies == nil {
+			s.Copies = hostCopies()
+		}
+	}
+	return s, nil
+}
+
+// validate checks s for mistakes (including missing host files) so that
+// they can be reported before creating the sandbox. It returns all the
+// problems it finds.
+func (s *spec) validate() error {
+	var errs []error
+	addErr := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+	if s.Rootfs != "" {
+		if _, err := os.Stat(s.Rootfs); err != nil {
+			addErr("bad rootfs: %s", err)
+		}
+		if len(s.Copies) > 0 && !s.Overlay {
+			addErr("cannot copy files into a rootfs (it is read-only without overlay)")
+		}
+	}
+	if s.Keep != "" {
+		if !s.Overlay {
+			addErr("keep requires overlay")
+		}
+		if !filepath.IsAbs(s.Keep) {
+			addErr("keep %q is not an absolute path", s.Keep)
+		}
+	}
+	if s.Net != "none" && s.Net != "host" {
+		addErr("net must be none or host (got %q)", s.Net)
+	}
+	if s.TmpSize != "" && !tmpSizeRegexp.MatchString(s.TmpSize) {
+		addErr("bad tmpSize %q (want a number with an optional k, m, g, or %% suffix)", s.TmpSize)
+	}
+	if s.Memory != "" && !memorySizeRegexp.MatchString(s.Memory) {
+		addErr("bad memory %q (want a number with an optional k, m, or g suffix)", s.Memory)
+	}
+	if s.Pids < 0 {
+		addErr("pids is negative")
+	}
+	if s.CPU < 0 {
+		addErr("cpu is negative")
+	}
+	for _, name := range slices.Sorted(maps.Keys(s.Rlimits)) {
+		if _, ok := rlimitResources[name]; !ok {
+			addErr("unknown rlimit %q", name)
+		}
+	}
+	if s.Seccomp != nil {
+		if err := s.Seccomp.check(); err != nil {
+			addErr("seccomp: %s", err)
+		}
+	}
+	for i, m := range s.Mounts {
+		if err := checkHostPath(m.Source); err != nil {
+			addErr("mounts[%d]: %s", i, err)
+		}
+		if err := checkSandboxPath(m.Target); err != nil {
+			addErr("mounts[%d]: %s", i, err)
+		}
+		if s.Overlay && strings.ContainsAny(m.Source, ",:") {
+			// The source may be an overlay lowerdir option.
+			addErr("mounts[%d]: source %q cannot contain ',' or ':' with overlay", i, m.Source)
+		}
+	}
+	for i, c := range s.Copies {
+		if err := checkHostPath(c.Source); err != nil {
+			addErr("copies[%d]: %s", i, err)
+		}
+		target := c.Target
+		if target == "" {
+			target = c.Source
+		}
+		if err := checkSandboxPath(target); err != nil {
+			addErr("copies[%d]: %s", i, err)
+		}
+	}
+	for i, kv := range s.Env {
+		if k, _, ok := strings.Cut(kv, "="); !ok || k == "" {
+			addErr("env[%d]: %q is not of the form KEY=VALUE", i, kv)
+		}
+	}
+	if len(s.Hostname) > 64 {
+		addErr("hostname %q is longer than 64 bytes", s.Hostname)
+	}
+	if s.Hostname != "" && !sandboxNameRegexp.MatchString(s.Hostname) {
+		addErr("hostname %q must be letters, digits, '.', and '-'", s.Hostname)
+	}
+	if s.WorkDir != "" && !filepath.IsAbs(s.WorkDir) {
+		addErr("workDir %q is not an absolute path", s.WorkDir)
+	}
+	if err := checkIDMaps(s.UIDMap); err != nil {
+		addErr("uidMap: %s", err)
+	}
+	if err := checkIDMaps(s.GIDMap); err != nil {
+		addErr("gidMap: %s", err)
+	}
+	return errors.Join(errs...)
+}
+
+func checkHostPath(p string) error {
+	if p == "" {
+		return errors.New("empty source")
+	}
+	if !filepath.IsAbs(p) {
+		return fmt.Errorf("source %q is not an absolute path", p)
+	}
+	if _, err := os.Stat(p); err != nil {
+		return fmt.Errorf("bad source: %s", err)
+	}
+	return nil
+}
+
+func checkSandboxPath(p string) error {
+	if !filepath.IsAbs(p) {
+		return fmt.Errorf("target %q is not an absolute path", p)
+	}
+	if filepath.Clean(p) == "/" {
+		return errors.New("target cannot be /")
+	}
+	return nil
+}
+
+func checkIDMaps(maps []idMap) error {
+	if len(maps) == 0 {
+		return errors.New("no mappings")
+	}
+	for i, m := range maps {
+		if m.ContainerID < 0 || m.HostID < 0 || m.Size <= 0 {
+			return fmt.Errorf("bad mapping %+v", m)
+		}
+		for _, m1 := range maps[:i] {
+			if m.ContainerID < m1.ContainerID+m1.Size && m1.ContainerID < m.ContainerID+m.Size {
+				return fmt.Errorf("mappings %+v and %+v overlap", m1, m)
+			}
+		}
+	}
+	return nil
+}
+
+// bindFlag is a flag.Value for -bind src:dst[:rw] that appends to a list
+// of bind mounts.
+type bindFlag struct {
+	mounts *[]bindMount
+}
+
+func (f bindFlag) String() string { return "" }
+
+func (f bindFlag) Set(v string) error {
+	parts := strings.Split(v, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return errors.New("want src:dst or src:dst:rw")
+	}
+	if parts[0] == "" {
+		return errors.New("empty src")
+	}
+	m := bindMount{Source: parts[0], Target: parts[1]}
+	if len(parts) == 3 {
+		switch parts[2] {
+		case "rw":
+			m.RW = true
+		case "ro":
+		default:
+			return fmt.Errorf("bad mount option %q (want rw or ro)", parts[2])
+		}
+	}
+	src, err := filepath.Abs(m.Source)
+	if err != nil {
+		return err
+	}
+	m.Source = src
+	*f.mounts = append(*f.mounts, m)
+	return nil
+}
+
+// copyFlag is a flag.Value for -copy src[:dst] that appends to a list of
+// file copies.
+type copyFlag struct {
+	copies *[]fileCopy
+}
+
+func (f copyFlag) String() string { return "" }
+
+func (f copyFlag) Set(v string) error {
+	src, dst, _ := strings.Cut(v, ":")
+	if src == "" {
+		return errors.New("want src or src:dst")
+	}
+	src, err := filepath.Abs(src)
+	if err != nil {
+		return err
+	}
+	*f.copies = append(*f.copies, fileCopy{Source: src, Target: dst})
+	return nil
+}
+
+// rlimitFlag is a flag.Value for -rlimit NAME=N that adds to a map of
+// rlimits.
+type rlimitFlag struct {
+	rlimits *map[string]uint64
+}
+
+func (f rlimitFlag) String() string { return "" }
+
+func (f rlimitFlag) Set(v string) error {
+	name, n, ok := strings.Cut(v, "=")
+	if !ok {
+		return errors.New("want NAME=N")
+	}
+	name = strings.ToLower(name)
+	if _, ok := rlimitResources[name]; !ok {
+		return fmt.Errorf("unknown rlimit %q", name)
+	}
+	limit, err := strconv.ParseUint(n, 10, 64)
+	if err != nil {
+		return fmt.Errorf("bad limit %q", n)
+	}
+	if *f.rlimits == nil {
+		*f.rlimits = make(map[string]uint64)
+	}
+	(*f.rlimits)[name] = limit
+	return nil
+}
+
+// seccompFlag is a flag.Value for -seccomp, which is either "default" (for
+// the default deny-list) or the name of a JSON seccomp profile.
+type seccompFlag struct {
+	profile **seccompProfile
+}
+
+func (f seccompFlag) String() string { return "" }
+
+func (f seccompFlag) Set(v string) error {
+	if v == "default" {
+		*f.profile = new(seccompProfile)
+		return nil
+	}
+	p, err := loadSeccompProfile(v)
+	if err != nil {
+		return err
+	}
+	*f.profile = p
+	return nil
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadSpec(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "spec.json")
+	const js = `{
+	"net": "none",
+	"mounts": [{"source": "/usr", "target": "/usr"}, {"source": "/src", "target": "/work", "rw": true}],
+	"env": ["FOO=bar"],
+	"hostname": "sandbox"
+}`
+	if err := os.WriteFile(name, []byte(js), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := loadSpec(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := defaultSpec()
+	want.Net = "none"
+	want.Mounts = []bindMount{
+		{Source: "/usr", Target: "/usr"},
+		{Source: "/src", Target: "/work", RW: true},
+	}
+	want.Copies = hostCopies()
+	want.Env = []string{"FOO=bar"}
+	want.Hostname = "sandbox"
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got spec\n%+v\nwant\n%+v", got, want)
+	}
+
+	// There are no default mounts or copies with a rootfs.
+	if err := os.WriteFile(name, []byte(`{"rootfs": "image.tar"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	got, err = loadSpec(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = defaultSpec()
+	want.Rootfs = "image.tar"
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got spec\n%+v\nwant\n%+v", got, want)
+	}
+
+	if err := os.WriteFile(name, []byte(`{"hostnam": "x"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadSpec(name); err == nil || !strings.Contains(err.Error(), `unknown field "hostnam"`) {
+		t.Errorf("loading spec with unknown field: got err %v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	s := defaultSpec()
+	s.Mounts = hostMounts()
+	s.Copies = hostCopies()
+	if err := s.validate(); err != nil {
+		t.Errorf("default spec is invalid: %s", err)
+	}
+	for _, tt := range []struct {
+		name   string
+		modify func(*spec)
+		want   string
+	}{
+		{
+			"missing rootfs",
+			func(s *spec) { s.Rootfs = "/does/not/exist.tar" },
+			"bad rootfs: stat /does/not/exist.tar: no such file or directory",
+		},
+		{
+			"rootfs copies",
+			func(s *spec) {
+				s.Rootfs = "/etc/passwd"
+				s.Copies = hostCopies()
+			},
+			"cannot copy files into a rootfs",
+		},
+		{
+			"bad hostname",
+			func(s *spec) { s.Hostname = "my/box" },
+			`hostname "my/box" must be letters, digits, '.', and '-'`,
+		},
+		{
+			"keep without overlay",
+			func(s *spec) { s.Keep = "/tmp/keep" },
+			"keep requires overlay",
+		},
+		{
+			"memory",
+			func(s *spec) { s.Memory = "1 GB" },
+			`bad memory "1 GB"`,
+		},
+		{
+			"rlimit",
+			func(s *spec) { s.Rlimits = map[string]uint64{"files": 10} },
+			`unknown rlimit "files"`,
+		},
+		{
+			"seccomp",
+			func(s *spec) { s.Seccomp = &seccompProfile{Default: "deny", Allow: []string{"read", "fork2"}} },
+			`seccomp: unknown syscall "fork2"`,
+		},
+		{
+			"net",
+			func(s *spec) { s.Net = "bridge" },
+			`net must be none or host (got "bridge")`,
+		},
+		{
+			"tmp",
+			func(s *spec) { s.TmpSize = "64 MB" },
+			`bad tmpSize "64 MB"`,
+		},
+		{
+			"missing source",
+			func(s *spec) { s.Mounts = append(s.Mounts, bindMount{Source: "/does/not/exist", Target: "/x"}) },
+			"mounts[0]: bad source: stat /does/not/exist: no such file or directory",
+		},
+		{
+			"relative target",
+			func(s *spec) { s.Mounts = []bindMount{{Source: "/usr", Target: "usr"}} },
+			`mounts[0]: target "usr" is not an absolute path`,
+		},
+		{
+			"root target",
+			func(s *spec) { s.Copies = []fileCopy{{Source: "/etc/passwd", Target: "/"}} },
+			"copies[0]: target cannot be /",
+		},
+		{
+			"env",
+			func(s *spec) { s.Env = []string{"FOO"} },
+			`env[0]: "FOO" is not of the form KEY=VALUE`,
+		},
+		{
+			"workdir",
+			func(s *spec) { s.WorkDir = "work" },
+			`workDir "work" is not an absolute path`,
+		},
+		{
+			"no uid map",
+			func(s *spec) { s.UIDMap = nil },
+			"uidMap: no mappings",
+		},
+		{
+			"overlapping gid maps",
+			func(s *spec) {
+				s.GIDMap = []idMap{
+					{ContainerID: 0, HostID: 1000, Size: 10},
+					{ContainerID: 5, HostID: 2000, Size: 1},
+				}
+			},
+			"gidMap: mappings {ContainerID:0 HostID:1000 Size:10} and {ContainerID:5 HostID:2000 Size:1} overlap",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s := defaultSpec()
+			tt.modify(s)
+			err := s.validate()
+			if err == nil {
+				t.Fatalf("got no error; want %q", tt.want)
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got error %q; want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestBindFlag(t *testing.T) {
+	var mounts []bindMount
+	f := bindFlag{&mounts}
+	for _, v := range []string{"/a:/b", "/c:/d:rw", "/e:/f:ro"} {
+		if err := f.Set(v); err != nil {
+			t.Fatalf("Set(%q): %s", v, err)
+		}
+	}
+	want := []bindMount{
+		{Source: "/a", Target: "/b"},
+		{Source: "/c", Target: "/d", RW: true},
+		{Source: "/e", Target: "/f"},
+	}
+	if !reflect.DeepEqual(mounts, want) {
+		t.Errorf("got mounts %+v; want %+v", mounts, want)
+	}
+	for _, v := range []string{"/a", ":/b", "/a:/b:rx", "/a:/b:rw:x"} {
+		if err := f.Set(v); err == nil {
+			t.Errorf("Set(%q): got no error", v)
+		}
+	}
+}
+
+func TestRlimitFlag(t *testing.T) {
+	var rlimits map[string]uint64
+	f := rlimitFlag{&rlimits}
+	for _, v := range []string{"nofile=1024", "CORE=0", "nofile=2048"} {
+		if err := f.Set(v); err != nil {
+			t.Fatalf("Set(%q): %s", v, err)
+		}
+	}
+	want := map[string]uint64{"nofile": 2048, "core": 0}
+	if !reflect.DeepEqual(rlimits, want) {
+		t.Errorf("got rlimits %v; want %v", rlimits, want)
+	}
+	for _, v := range []string{"nofile", "files=10", "nofile=-1", "nofile=unlimited"} {
+		if err := f.Set(v); err == nil {
+			t.Errorf("Set(%q): got no error", v)
+		}
+	}
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+)
+
+// A sandboxState records a running sandbox in the state directory so that
+// namespacify exec, ls, and kill can find it.
+type sandboxState struct {
+	Name string `json:"name"`
+	// PID is the host PID of the sandbox's init process. StartTime is
+	// its start time (from /proc/PID/stat), which tells us whether the
+	// PID has been reused since.
+	PID       int       `json:"pid"`
+	StartTime string    `json:"startTime"`
+	Args      []string  `json:"args"`
+	Spec      *spec     `json:"spec"`
+	Cgroup    string    `json:"cgroup,omitempty"`
+	Created   time.Time `json:"created"`
+}
+
+// sandboxNameRegexp matches valid sandbox names, which are also hostnames
+// and the names of state files.
+var sandboxNameRegexp = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9.-]*$`)
+
+// stateDir returns the directory holding the current user's sandbox
+// states, creating it if needed.
+//
+// The directory's name in the fallback location is predictable, so
+// another user could create it first and fill it with states for exec
+// and kill to act on. We only use a directory that is ours alone.
+func stateDir() (string, error) {
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if dir != "" {
+		dir = filepath.Join(dir, "namespacify")
+	} else {
+		dir = filepath.Join(os.TempDir(), fmt.Sprintf("namespacify-%d", os.Getuid()))
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	fi, err := os.Lstat(dir)
+	if err != nil {
+		return "", err
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !fi.IsDir() || !ok || int(st.Uid) != os.Getuid() || fi.Mode().Perm() != 0700 {
+		return "", fmt.Errorf("state directory %s must be a directory (not a symlink) owned by uid %d with mode 0700", dir, os.Getuid())
+	}
+	return dir, nil
+}
+
+// newSandboxState makes the state of the sandbox whose init process is
+// pid.
+func newSandboxState(name string, pid int, args []string, sp *spec, cg *cgroup) (*sandboxState, error) {
+	startTime, err := procStartTime(pid)
+	if err != nil {
+		return nil, err
+	}
+	s := &sandboxState{
+		Name:      name,
+		PID:       pid,
+		StartTime: startTime,
+		Args:      args,
+		Spec:      sp,
+		Created:   time.Now(),
+	}
+	if cg != nil {
+		s.Cgroup = cg.dir
+	}
+	return s, nil
+}
+
+// save records s in the state directory. It fails if another running
+// sandbox has the same name.
+//
+// The state is written to a temporary file and then linked into place, so
+// that nobody sees a partly written state and an existing state isn't
+// replaced.
+func (s *sandboxState) save() error {
+	dir, err := stateDir()
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, "."+s.Name+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(b)
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+	if err != nil {
+		return err
+	}
+	name := filepath.Join(dir, s.Name+".json")
+	for {
+		err := os.Link(f.Name(), name)
+		if !errors.Is(err, fs.ErrExist) {
+			return err
+		}
+		_, err = loadState(s.Name)
+		if err == nil {
+			return fmt.Errorf("a sandbox named %s is already running", s.Name)
+		}
+		// Try again if the existing state is gone (because it was
+		// stale and loadState removed it); otherwise, we'd only find
+		// the same state again.
+		var nr *notRunningError
+		if !errors.As(err, &nr) || !nr.gone {
+			return err
+		}
+	}
+}
+
+// remove removes s from the state directory.
+func (s *sandboxState) remove() error {
+	dir, err := stateDir()
+	if err != nil {
+		return err
+	}
+	return os.Remove(filepath.Join(dir, s.Name+".json"))
+}
+
+// running reports whether the sandbox's init process is still running.
+func (s *sandboxState) running() bool {
+	startTime, err := procStartTime(s.PID)
+	return err == nil && startTime == s.StartTime
+}
+
+// A notRunningError is the error loadState returns if there is no running
+// sandbox with the given name.
+type notRunningError struct {
+	name string
+	// gone says that there is no state for the sandbox any more:
+	// either there never was one, or it was stale and was removed.
+	gone bool
+}
+
+func (e *notRunningError) Error() string {
+	return fmt.Sprintf("no sandbox named %s is running", e.name)
+}
+
+// loadState loads the state of the running sandbox name. It removes the
+// state of a sandbox that is no longer running (because namespacify was
+// killed before it could clean up).
+func loadState(name string) (*sandboxState, error) {
+	if !sandboxNameRegexp.MatchString(name) {
+		return nil, fmt.Errorf("bad sandbox name %q", name)
+	}
+	dir, err := stateDir()
+	if err != nil {
+		return nil, err
+	}
+	file := filepath.Join(dir, name+".json")
+	b, err := os.ReadFile(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, &notRunningError{name: name, gone: true}
+	}
+	if err != nil {
+		return nil, err
+	}
+	var s sandboxState
+	if err := json.Unmarshal(b, &s); err != nil {
+		return nil, fmt.Errorf("bad state for sandbox %s in %s: %s", name, file, err)
+	}
+	if !s.running() {
+		err := s.remove()
+		return nil, &notRunningError{name: name, gone: err == nil || errors.Is(err, fs.ErrNotExist)}
+	}
+	return &s, nil
+}
+
+// listStates loads the states of all the running sandboxes.
+func listStates() ([]*sandboxState, error) {
+	dir, err := stateDir()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var states []*sandboxState
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), ".json")
+		if !ok {
+			continue
+		}
+		if s, err := loadState(name); err == nil {
+			states = append(states, s)
+		}
+	}
+	slices.SortFunc(states, func(a, b *sandboxState) int { return a.Created.Compare(b.Created) })
+	return states, nil
+}
+
+// procStartTime returns the start time of process pid (in clock ticks
+// since boot).
+func procStartTime(pid int) (string, error) {
+	b, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return "", err
+	}
+	// The command name (field 2) is in parentheses and may contain
+	// spaces, so start after it. The start time is field 22.
+	i := strings.LastIndexByte(string(b), ')')
+	if i < 0 {
+		return "", fmt.Errorf("bad /proc/%d/stat", pid)
+	}
+	fields := strings.Fields(string(b[i+1:]))
+	if len(fields) < 20 {
+		return "", fmt.Errorf("bad /proc/%d/stat", pid)
+	}
+	return fields[19], nil
+}
+
+// runExec implements namespacify exec NAME [--] command [args...].
+func runExec(args []string) {
+	fs := flag.NewFlagSet("exec", flag.ExitOnError)
+	fs.Parse(args)
+	args = fs.Args()
+	if len(args) > 1 && args[1] == "--" {
+		args = slices.Delete(args, 1, 2)
+	}
+	if len(args) < 2 {
+		log.Fatalf("usage: %s exec NAME [--] command [args...]", os.Args[0])
+	}
+	s, err := loadState(args[0])
+	if err != nil {
+		log.Fatal(err)
+	}
+	pid, err := enterSandbox(s, args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+	var ws syscall.WaitStatus
+	for {
+		_, err = syscall.Wait4(pid, &ws, 0, nil)
+		if err != syscall.EINTR {
+			break
+		}
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if ws.Signaled() {
+		os.Exit(128 + int(ws.Signal()))
+	}
+	os.Exit(ws.ExitStatus())
+}
+
+// runLs implements namespacify ls.
+func runLs(args []string) {
+	fs := flag.NewFlagSet("ls", flag.ExitOnError)
+	fs.Parse(args)
+	if fs.NArg() > 0 {
+		log.Fatalf("usage: %s ls", os.Args[0])
+	}
+	states, err := listStates()
+	if err != nil {
+		log.Fatal(err)
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tPID\tCREATED\tCOMMAND")
+	for _, s := range states {
+		created := s.Created.Format(time.DateTime)
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\n", s.Name, s.PID, created, strings.Join(s.Args, " "))
+	}
+	tw.Flush()
+}
+
+// runKill implements namespacify kill NAME. Killing the sandbox's init
+// process kills everything in the sandbox.
+func runKill(args []string) {
+	fs := flag.NewFlagSet("kill", flag.ExitOnError)
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		log.Fatalf("usage: %s kill NAME", os.Args[0])
+	}
+	s, err := loadState(fs.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	// Holding the process (a pidfd) means that the PID can't be reused
+	// once we've checked that it's still the sandbox's.
+	p, err := os.FindProcess(s.PID)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if !s.running() {
+		log.Fatalf("no sandbox named %s is running", s.Name)
+	}
+	if err := p.Signal(syscall.SIGKILL); err != nil {
+		log.Fatal(err)
+	}
+}
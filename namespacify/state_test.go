@@ -0,0 +1,113 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSaveState(t *testing.T) {
+	t.Setenv("XDG_RUNTIME_DIR", t.TempDir())
+	dir, err := stateDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := newSandboxState("box", os.Getpid(), []string{"sh"}, defaultSpec(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.save(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadState("box"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.save(); err == nil || !strings.Contains(err.Error(), "already running") {
+		t.Errorf("saving a running sandbox's name again: got err %v", err)
+	}
+
+	// A stale state (of a process that has exited) is replaced.
+	stale := *s
+	stale.StartTime = "0"
+	if err := s.remove(); err != nil {
+		t.Fatal(err)
+	}
+	if err := stale.save(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.save(); err != nil {
+		t.Errorf("cannot replace stale state: %s", err)
+	}
+
+	// A state that can't be parsed (say, left by a crash) is an error.
+	if err := os.WriteFile(filepath.Join(dir, "bad.json"), nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	s.Name = "bad"
+	if err := s.save(); err == nil || !strings.Contains(err.Error(), "bad state") {
+		t.Errorf("saving over an unparsable state: got err %v", err)
+	}
+
+	// Only the states are left in the directory.
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	if want := "bad.json box.json"; strings.Join(names, " ") != want {
+		t.Errorf("state directory has %q; want %q", names, want)
+	}
+}
+
+func TestStateDirOwnership(t *testing.T) {
+	runtimeDir := t.TempDir()
+	t.Setenv("XDG_RUNTIME_DIR", runtimeDir)
+	dir := filepath.Join(runtimeDir, "namespacify")
+
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stateDir(); err == nil {
+		t.Error("accepted a state directory with mode 0755")
+	}
+	if err := os.Remove(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	other := t.TempDir()
+	if err := os.Chmod(other, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(other, dir); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stateDir(); err == nil {
+		t.Error("accepted a symlink as the state directory")
+	}
+	if err := os.Remove(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	if os.Getuid() == 0 {
+		if err := os.Mkdir(dir, 0o700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chown(dir, 12345, 12345); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := stateDir(); err == nil {
+			t.Error("accepted a state directory owned by another user")
+		}
+		if err := os.Remove(dir); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := stateDir(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// An idMapper maps the sandbox's IDs using a setuid helper (newuidmap or
+// newgidmap from shadow-utils), which lets the maps include the user's
+// subordinate ID ranges. Without privileges, we can only map our own ID.
+type idMapper struct {
+	helper string
+	maps   []idMap
+}
+
+// subIDMapper returns an idMapper that adds the current user's ranges in
+// file (/etc/subuid or /etc/subgid) to maps. It returns nil if the user
+// has no ranges or the helper isn't installed, in which case only the
+// user's own ID is mapped.
+func subIDMapper(helper, file string, maps []idMap) (*idMapper, error) {
+	u, err := user.Current()
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	ranges, err := parseSubIDs(f, u.Username, u.Uid)
+	if err != nil {
+		return nil, fmt.Errorf("bad %s: %s", file, err)
+	}
+	if len(ranges) == 0 {
+		return nil, nil
+	}
+	path, err := exec.LookPath(helper)
+	if err != nil {
+		return nil, nil
+	}
+	return &idMapper{helper: path, maps: addSubIDs(maps, ranges)}, nil
+}
+
+// parseSubIDs reads the ranges of a user (given by name or ID) from an
+// /etc/subuid or /etc/subgid file. The ranges are returned as idMaps
+// without container IDs.
+func parseSubIDs(r io.Reader, name, id string) ([]idMap, error) {
+	var ranges []idMap
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, ":")
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("bad line %q", line)
+		}
+		if fields[0] != name && fields[0] != id {
+			continue
+		}
+		start, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("bad line %q", line)
+		}
+		count, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("bad line %q", line)
+		}
+		ranges = append(ranges, idMap{HostID: start, Size: count})
+	}
+	return ranges, scanner.Err()
+}
+
+// addSubIDs appends the subordinate ID ranges to maps, mapping them to the
+// container IDs after the ones that maps already uses. (With the default
+// maps, the user is root in the sandbox and the ranges start at ID 1.)
+func addSubIDs(maps, ranges []idMap) []idMap {
+	next := 0
+	for _, m := range maps {
+		next = max(next, m.ContainerID+m.Size)
+	}
+	maps = append([]idMap(nil), maps...)
+	for _, r := range ranges {
+		if r.Size <= 0 {
+			continue
+		}
+		maps = append(maps, idMap{ContainerID: next, HostID: r.HostID, Size: r.Size})
+		next += r.Size
+	}
+	return maps
+}
+
+// apply writes the maps of the process pid with the helper.
+func (m *idMapper) apply(pid int) error {
+	args := []string{strconv.Itoa(pid)}
+	for _, im := range m.maps {
+		args = append(args, strconv.Itoa(im.ContainerID), strconv.Itoa(im.HostID), strconv.Itoa(im.Size))
+	}
+	out, err := exec.Command(m.helper, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s failed: %s\n%s", m.helper, err, out)
+	}
+	return nil
+}
+
+// idMapSyncFD is the descriptor of the pipe that the child waits on until
+// its ID maps are written.
+const idMapSyncFD = 3
+
+// waitForIDMaps waits until the parent has mapped the child's IDs with the
+// helpers and then re-execs the child. The child exec'd before its user
+// ID was mapped, so it lost its capabilities in the user namespace; now
+// that it is root there, a new exec gets them back.
+func waitForIDMaps(cfg childConfig) {
+	f := os.NewFile(idMapSyncFD, "idmap sync")
+	b := make([]byte, 1)
+	if _, err := io.ReadFull(f, b); err != nil {
+		// The parent failed and will report the error.
+		os.Exit(1)
+	}
+	f.Close()
+	cfg.WaitIDMaps = false
+	b, err := json.Marshal(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	os.Setenv(reexecEnv, string(b))
+	if err := syscall.Exec("/proc/self/exe", os.Args, os.Environ()); err != nil {
+		log.Fatalln("Cannot re-exec after mapping IDs:", err)
+	}
+}
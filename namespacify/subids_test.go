@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseSubIDs(t *testing.T) {
+	const subuid = `# comment
+alice:100000:65536
+bob:165536:65536
+
+1000:300000:1000
+`
+	got, err := parseSubIDs(strings.NewReader(subuid), "alice", "1000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []idMap{{HostID: 100000, Size: 65536}, {HostID: 300000, Size: 1000}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got ranges %+v; want %+v", got, want)
+	}
+	got, err = parseSubIDs(strings.NewReader(subuid), "carol", "1001")
+	if err != nil || len(got) != 0 {
+		t.Errorf("got ranges %+v, err %v for user with no ranges", got, err)
+	}
+	if _, err := parseSubIDs(strings.NewReader("alice:100000\n"), "alice", "1000"); err == nil {
+		t.Error("got no error for bad line")
+	}
+}
+
+func TestAddSubIDs(t *testing.T) {
+	maps := []idMap{{ContainerID: 0, HostID: 1000, Size: 1}}
+	ranges := []idMap{{HostID: 100000, Size: 65536}, {HostID: 300000, Size: 1000}}
+	got := addSubIDs(maps, ranges)
+	want := []idMap{
+		{ContainerID: 0, HostID: 1000, Size: 1},
+		{ContainerID: 1, HostID: 100000, Size: 65536},
+		{ContainerID: 65537, HostID: 300000, Size: 1000},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got maps %+v; want %+v", got, want)
+	}
+	if len(maps) != 1 {
+		t.Error("addSubIDs modified its argument")
+	}
+}
+
+func TestSubIDMapperFallback(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "subuid")
+	line := fmt.Sprintf("%d:100000:65536\n", os.Getuid())
+	if err := os.WriteFile(file, []byte(line), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// Without the helper, only the user's own ID is mapped.
+	m, err := subIDMapper("namespacify-no-such-helper", file, nil)
+	if err != nil || m != nil {
+		t.Errorf("got mapper %+v, err %v without the helper; want neither", m, err)
+	}
+	// Likewise without any ranges.
+	m, err = subIDMapper("sh", filepath.Join(t.TempDir(), "missing"), nil)
+	if err != nil || m != nil {
+		t.Errorf("got mapper %+v, err %v without ranges; want neither", m, err)
+	}
+	m, err = subIDMapper("sh", file, nil)
+	if err != nil || m == nil {
+		t.Errorf("got mapper %+v, err %v with ranges and a helper", m, err)
+	}
+}
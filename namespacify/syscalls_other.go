@@ -0,0 +1,10 @@
+//go:build !amd64 && !arm64
+
+package main
+
+// Seccomp filtering is only supported on the architectures that have a
+// table of syscall numbers (see mksyscalls.go).
+
+const auditArch = 0
+
+var syscallNumbers map[string]uint32
@@ -0,0 +1,456 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+
+	"golang.org/x/tools/go/analysis"
+)
+
+// A 3-clause loop and its range-over-int counterpart only behave the same
+// if the loop var counts up from 0 by 1s and the bound has the same value
+// on every iteration. (The range form evaluates its bound once, before the
+// loop begins.) The checks in this file decline loops where the body may
+// break one of those assumptions.
+
+// fileFacts are facts about how the variables in a file are used, which
+// we gather in one pass over the file.
+type fileFacts struct {
+	// addrTaken holds the vars whose address is taken anywhere, either
+	// explicitly (&v) or implicitly (by calling a pointer method).
+	addrTaken map[types.Object]bool
+	// closureWritten holds the vars that are assigned in a func literal.
+	closureWritten map[types.Object]bool
+}
+
+func gatherFileFacts(pass *analysis.Pass, file *ast.File) *fileFacts {
+	ff := &fileFacts{
+		addrTaken:      make(map[types.Object]bool),
+		closureWritten: make(map[types.Object]bool),
+	}
+	var funcLits []*ast.FuncLit
+	ast.Inspect(file, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.FuncLit:
+			funcLits = append(funcLits, n)
+		default:
+			if obj, indirect := addressed(pass, n); obj != nil && !indirect {
+				ff.addrTaken[obj] = true
+			}
+		}
+		return true
+	})
+	for _, lit := range funcLits {
+		for _, w := range written(pass, lit.Body) {
+			if !w.indirect {
+				ff.closureWritten[w.obj] = true
+			}
+		}
+	}
+	return ff
+}
+
+// checkSound checks whether a loop with loop var ident and the given
+// bound can be rewritten to range over an integer. If not, it returns a
+// description of the reason.
+func checkSound(pass *analysis.Pass, ff *fileFacts, stmt *ast.ForStmt, ident *ast.Ident, bound ast.Expr) string {
+	loopVar := pass.TypesInfo.Defs[ident]
+	var reads []types.Object
+	var locs []location
+	if pass.TypesInfo.Types[bound].Value == nil {
+		var reason string
+		reads, locs, reason = boundReads(pass, bound)
+		if reason != "" {
+			return reason
+		}
+	}
+	boundIndirect := len(locs) > 0
+	// A variable that the bound reads may itself be written through a
+	// pointer if its address is taken (or, for a package-level var,
+	// may be taken in another file).
+	aliasLocs := locs
+	for _, obj := range reads {
+		if obj.Parent() == pass.Pkg.Scope() || ff.addrTaken[obj] {
+			aliasLocs = append(aliasLocs, location{kind: locVar, typ: obj.Type()})
+		}
+	}
+	isRead := func(obj types.Object) bool {
+		for _, r := range reads {
+			if r == obj {
+				return true
+			}
+		}
+		return false
+	}
+
+	// modifies reports whether a write to obj (through a pointer, if
+	// indirect) may change the bound.
+	modifies := func(obj types.Object, indirect bool) bool {
+		return isRead(obj) && (!indirect || boundIndirect)
+	}
+	// aliases reports whether a write to loc may change the memory that
+	// the bound reads, even though it goes through some other variable.
+	aliases := func(loc location) bool {
+		for _, l := range aliasLocs {
+			if mayAlias(loc, l) {
+				return true
+			}
+		}
+		return false
+	}
+	var reason string
+	var hasCall bool
+	ast.Inspect(stmt.Body, func(n ast.Node) bool {
+		if reason != "" {
+			return false
+		}
+		if obj, indirect := addressed(pass, n); obj != nil {
+			switch {
+			case obj == loopVar && !indirect:
+				reason = fmt.Sprintf("loop body takes the address of %s", obj.Name())
+			case modifies(obj, indirect):
+				reason = fmt.Sprintf("loop body takes the address of %s, which the bound reads", obj.Name())
+			}
+			return true
+		}
+		switch n := n.(type) {
+		case *ast.AssignStmt, *ast.IncDecStmt, *ast.RangeStmt:
+			for _, w := range written(pass, n) {
+				switch {
+				case w.obj == loopVar && !w.indirect:
+					reason = fmt.Sprintf("loop body assigns to %s", w.obj.Name())
+				case modifies(w.obj, w.indirect):
+					reason = fmt.Sprintf("loop body modifies %s, which the bound reads", w.obj.Name())
+				case (w.indirect || ff.addrTaken[w.obj]) && aliases(locationOf(pass, w.expr)):
+					reason = fmt.Sprintf("loop body modifies %s, which may alias the bound", types.ExprString(w.expr))
+				}
+			}
+		case *ast.CallExpr:
+			if pass.TypesInfo.Types[n.Fun].IsType() {
+				break // conversion
+			}
+			if b, ok := builtin(pass, n); ok {
+				switch b.Name() {
+				case "clear", "copy", "delete":
+					// These modify what their first argument
+					// refers to.
+					if obj, _ := rootObj(pass, n.Args[0]); obj != nil && modifies(obj, true) {
+						reason = fmt.Sprintf("loop body modifies %s, which the bound reads", obj.Name())
+					} else if loc, ok := contentsOf(pass, n.Args[0]); ok && aliases(loc) {
+						reason = fmt.Sprintf("loop body modifies %s, which may alias the bound", types.ExprString(n.Args[0]))
+					}
+				}
+				break
+			}
+			hasCall = true
+		}
+		return true
+	})
+	if reason != "" || !hasCall {
+		return reason
+	}
+
+	// The body calls functions. They can't modify local variables unless
+	// those variables escape.
+	if boundIndirect {
+		return "loop body calls functions that may modify the memory that the bound reads"
+	}
+	for _, obj := range reads {
+		if obj.Parent() == pass.Pkg.Scope() || ff.addrTaken[obj] || ff.closureWritten[obj] {
+			return fmt.Sprintf("loop body calls functions that may modify %s, which the bound reads", obj.Name())
+		}
+	}
+	return ""
+}
+
+// boundReads checks that a non-constant bound has no side effects and
+// collects the variables that it reads. It also collects the locations
+// that the bound reads through a pointer (or a slice or map), which may be
+// modified via an alias. If the bound is too complicated to analyze, it
+// returns a description of the reason.
+func boundReads(pass *analysis.Pass, bound ast.Expr) (reads []types.Object, locs []location, reason string) {
+	ast.Inspect(bound, func(n ast.Node) bool {
+		if reason != "" {
+			return false
+		}
+		switch n := n.(type) {
+		case nil, *ast.BasicLit, *ast.ParenExpr, *ast.BinaryExpr, *ast.SliceExpr:
+		case *ast.Ident:
+			if v, ok := pass.TypesInfo.Uses[n].(*types.Var); ok {
+				reads = append(reads, v)
+			}
+		case *ast.UnaryExpr:
+			if n.Op == token.ARROW {
+				reason = "bound receives from a channel"
+			}
+		case *ast.StarExpr:
+			locs = append(locs, locationOf(pass, n))
+		case *ast.IndexExpr:
+			switch pass.TypesInfo.TypeOf(n.X).Underlying().(type) {
+			case *types.Slice, *types.Map, *types.Pointer:
+				locs = append(locs, locationOf(pass, n))
+			}
+		case *ast.SelectorExpr:
+			sel, ok := pass.TypesInfo.Selections[n]
+			if !ok {
+				break // qualified identifier
+			}
+			if sel.Kind() != types.FieldVal {
+				reason = fmt.Sprintf("bound uses method %s", n.Sel.Name)
+				break
+			}
+			if sel.Indirect() {
+				locs = append(locs, locationOf(pass, n))
+			}
+			// Don't count the field name as a read of a var.
+			ast.Inspect(n.X, func(n ast.Node) bool {
+				if id, ok := n.(*ast.Ident); ok {
+					if v, ok := pass.TypesInfo.Uses[id].(*types.Var); ok {
+						reads = append(reads, v)
+					}
+				}
+				return true
+			})
+			return false
+		case *ast.CallExpr:
+			if pass.TypesInfo.Types[n.Fun].IsType() {
+				break // conversion
+			}
+			b, ok := builtin(pass, n)
+			if !ok {
+				reason = fmt.Sprintf("bound calls %s, which may have side effects", types.ExprString(n.Fun))
+				break
+			}
+			switch b.Name() {
+			case "len", "cap":
+				switch pass.TypesInfo.TypeOf(n.Args[0]).Underlying().(type) {
+				case *types.Chan:
+					reason = fmt.Sprintf("bound calls %s on a channel", b.Name())
+				case *types.Map:
+					loc, _ := contentsOf(pass, n.Args[0])
+					locs = append(locs, loc)
+				}
+			case "min", "max":
+			default:
+				reason = fmt.Sprintf("bound calls %s", b.Name())
+			}
+		default:
+			reason = "bound is too complicated to check"
+		}
+		return true
+	})
+	return reads, locs, reason
+}
+
+// A location describes some memory that is written or read, for checking
+// whether a write through one variable may change what a read through
+// another one sees.
+type location struct {
+	kind locationKind
+	typ  types.Type
+	// field is the field, for a locField.
+	field *types.Var
+}
+
+type locationKind int
+
+const (
+	locVar   locationKind = iota // a variable
+	locField                     // a struct field
+	locElem                      // an array or slice element
+	locMap                       // a map's contents (typ is the map type)
+	locDeref                     // what a pointer points to
+)
+
+// locationOf describes the memory that expr (an addressable expression or
+// a map index) refers to.
+func locationOf(pass *analysis.Pass, expr ast.Expr) location {
+	expr = ast.Unparen(expr)
+	typ := pass.TypesInfo.TypeOf(expr)
+	switch e := expr.(type) {
+	case *ast.StarExpr:
+		return location{kind: locDeref, typ: typ}
+	case *ast.SelectorExpr:
+		if sel, ok := pass.TypesInfo.Selections[e]; ok && sel.Kind() == types.FieldVal {
+			return location{kind: locField, typ: typ, field: sel.Obj().(*types.Var)}
+		}
+	case *ast.IndexExpr:
+		if t := pass.TypesInfo.TypeOf(e.X); isMap(t) {
+			return location{kind: locMap, typ: t}
+		}
+		return location{kind: locElem, typ: typ}
+	}
+	return location{kind: locVar, typ: typ}
+}
+
+// contentsOf describes the memory that a slice or map refers to.
+func contentsOf(pass *analysis.Pass, expr ast.Expr) (location, bool) {
+	t := pass.TypesInfo.TypeOf(expr)
+	switch u := t.Underlying().(type) {
+	case *types.Map:
+		return location{kind: locMap, typ: t}, true
+	case *types.Slice:
+		return location{kind: locElem, typ: u.Elem()}, true
+	}
+	return location{}, false
+}
+
+func isMap(t types.Type) bool {
+	_, ok := t.Underlying().(*types.Map)
+	return ok
+}
+
+// mayAlias reports whether writing w may change what is read at r. Types
+// with identical underlying types are treated alike, since a conversion
+// can turn a pointer to one into a pointer to the other.
+func mayAlias(w, r location) bool {
+	if w.kind == locMap || r.kind == locMap {
+		return w.kind == r.kind && types.Identical(w.typ.Underlying(), r.typ.Underlying())
+	}
+	if !types.Identical(w.typ.Underlying(), r.typ.Underlying()) {
+		// One may be part of the other.
+		return contains(w.typ, r.typ) || contains(r.typ, w.typ)
+	}
+	switch {
+	case w.kind == locDeref || r.kind == locDeref:
+		// A pointer can point to anything of its type.
+		return true
+	case w.kind == locField && r.kind == locField:
+		return w.field == r.field
+	default:
+		// A field is never an element (and vice versa), and a
+		// variable is neither.
+		return w.kind == r.kind && w.kind == locElem
+	}
+}
+
+// contains reports whether a value of type t holds a value of type u
+// (in a field or array element, and not through a pointer).
+func contains(t, u types.Type) bool {
+	switch t := t.Underlying().(type) {
+	case *types.Struct:
+		for f := range t.Fields() {
+			if types.Identical(f.Type().Underlying(), u.Underlying()) || contains(f.Type(), u) {
+				return true
+			}
+		}
+	case *types.Array:
+		return types.Identical(t.Elem().Underlying(), u.Underlying()) || contains(t.Elem(), u)
+	}
+	return false
+}
+
+// builtin returns the builtin function called by call, if any.
+func builtin(pass *analysis.Pass, call *ast.CallExpr) (*types.Builtin, bool) {
+	id, ok := ast.Unparen(call.Fun).(*ast.Ident)
+	if !ok {
+		return nil, false
+	}
+	b, ok := pass.TypesInfo.Uses[id].(*types.Builtin)
+	return b, ok
+}
+
+// addressed returns the variable whose address (or the address of
+// whose contents) is taken by n, if any, either explicitly (&v) or by
+// calling a pointer method (v.m()). It also reports whether the address
+// is of memory that v refers to (as in &v[0], for a slice v) rather than
+// of v itself.
+func addressed(pass *analysis.Pass, n ast.Node) (obj types.Object, indirect bool) {
+	switch n := n.(type) {
+	case *ast.UnaryExpr:
+		if n.Op == token.AND {
+			return rootObj(pass, n.X)
+		}
+	case *ast.SelectorExpr:
+		sel, ok := pass.TypesInfo.Selections[n]
+		if !ok || sel.Kind() != types.MethodVal {
+			return nil, false
+		}
+		if _, ok := sel.Obj().Type().(*types.Signature).Recv().Type().(*types.Pointer); !ok {
+			return nil, false
+		}
+		if _, ok := pass.TypesInfo.TypeOf(n.X).Underlying().(*types.Pointer); ok {
+			return nil, false
+		}
+		return rootObj(pass, n.X)
+	}
+	return nil, false
+}
+
+// A write is an assignment to a variable, or (if indirect) to memory
+// that the variable refers to. expr is the expression assigned to.
+type write struct {
+	obj      types.Object
+	indirect bool
+	expr     ast.Expr
+}
+
+// written returns the writes done by n (an assignment, increment, or range
+// statement, or a block that is searched for those).
+func written(pass *analysis.Pass, n ast.Node) []write {
+	var writes []write
+	add := func(exprs ...ast.Expr) {
+		for _, e := range exprs {
+			if obj, indirect := rootObj(pass, e); obj != nil {
+				writes = append(writes, write{obj, indirect, e})
+			}
+		}
+	}
+	ast.Inspect(n, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.AssignStmt:
+			if n.Tok != token.DEFINE {
+				add(n.Lhs...)
+			}
+		case *ast.IncDecStmt:
+			add(n.X)
+		case *ast.RangeStmt:
+			if n.Tok == token.ASSIGN {
+				add(n.Key, n.Value)
+			}
+		}
+		return true
+	})
+	return writes
+}
+
+// rootObj returns the variable at the root of an expression such as
+// v, v.f, v[i], or v.f[i].g, if any. It also reports whether the path
+// from the variable to the expression goes through a pointer, slice, or
+// map (so that the expression refers to memory outside of v).
+func rootObj(pass *analysis.Pass, expr ast.Expr) (obj types.Object, indirect bool) {
+	for {
+		switch e := expr.(type) {
+		case *ast.Ident:
+			if v, ok := pass.TypesInfo.Uses[e].(*types.Var); ok {
+				return v, indirect
+			}
+			return nil, false
+		case *ast.ParenExpr:
+			expr = e.X
+		case *ast.SelectorExpr:
+			sel, ok := pass.TypesInfo.Selections[e]
+			if !ok {
+				expr = e.Sel // qualified identifier
+				break
+			}
+			if sel.Indirect() {
+				indirect = true
+			}
+			expr = e.X
+		case *ast.IndexExpr:
+			switch pass.TypesInfo.TypeOf(e.X).Underlying().(type) {
+			case *types.Slice, *types.Map, *types.Pointer:
+				indirect = true
+			}
+			expr = e.X
+		case *ast.StarExpr:
+			indirect = true
+			expr = e.X
+		default:
+			return nil, false
+		}
+	}
+}
@@ -0,0 +1,9 @@
+package go121
+
+import "fmt"
+
+func f() {
+	for i := 0; i < 10; i++ { // want `cannot use range-over-integer: file's Go version is go1.21 \(range-over-integer requires go1.22\)`
+		fmt.Println(i)
+	}
+}
@@ -0,0 +1,11 @@
+//go:build go1.22
+
+package mixed
+
+import "fmt"
+
+func g() {
+	for i := 0; i < 10; i++ { // want "could use range-over-integer"
+		fmt.Println(i)
+	}
+}
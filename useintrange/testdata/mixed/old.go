@@ -0,0 +1,10 @@
+package mixed
+
+import "fmt"
+
+// This file uses the module's Go version (1.21), so it is left alone.
+func f() {
+	for i := 0; i < 10; i++ {
+		fmt.Println(i)
+	}
+}
@@ -0,0 +1,133 @@
+package a
+
+import (
+	"fmt"
+	tm "time"
+)
+
+const N = 10
+
+type myInt int
+
+func f(xs []int, n int) {
+	for i := 0; i < 10; i++ { // want "could use range-over-integer"
+		fmt.Println(i)
+	}
+	for i := 0; i < N; i++ { // want "could use range-over-integer"
+		fmt.Println(i)
+	}
+	for i := 0; i < 3; i++ { // want "could use range-over-integer"
+		fmt.Println("hello")
+	}
+
+	// Other ways of incrementing.
+	for i := 0; i < N; i += 1 { // want "could use range-over-integer"
+		fmt.Println(i)
+	}
+	for i := 0; i < N; i = i + 1 { // want "could use range-over-integer"
+		fmt.Println(i)
+	}
+	for i := 0; i < N; i += 2 {
+		fmt.Println(i)
+	}
+	for i := 0; i < N; i = 1 + i {
+		fmt.Println(i)
+	}
+	for i := 0; i < N; i -= 1 {
+		fmt.Println(i)
+	}
+
+	// Other ways of writing the condition.
+	for i := 0; i <= N-1; i++ { // want "could use range-over-integer"
+		fmt.Println(i)
+	}
+	for i := 0; N > i; i++ { // want "could use range-over-integer"
+		fmt.Println(i)
+	}
+	for i := 0; N-1 >= i; i++ { // want "could use range-over-integer"
+		fmt.Println(i)
+	}
+	for i := 0; i <= N; i++ {
+		fmt.Println(i)
+	}
+	for i := 0; i <= N-2; i++ {
+		fmt.Println(i)
+	}
+	for i := 0; i > N; i++ {
+		fmt.Println(i)
+	}
+	for i := 0; N < i; i++ {
+		fmt.Println(i)
+	}
+
+	// Other ways of writing 0.
+	for i := 0x0; i < 5; i++ { // want "could use range-over-integer"
+		fmt.Println(i)
+	}
+	for i := 0_0; i < 0x1_0; i++ { // want "could use range-over-integer"
+		fmt.Println(i)
+	}
+	for i := 0b0; i <= 0b1_01-0x1; i++ { // want "could use range-over-integer"
+		fmt.Println(i)
+	}
+	for i := 0x1; i < 5; i++ {
+		fmt.Println(i)
+	}
+	for i := 1_0; i < 50; i++ {
+		fmt.Println(i)
+	}
+
+	// Typed loop vars need the bound to be converted (unless the loop
+	// var isn't used).
+	for j := int64(0); j < 5; j = j + 1 { // want "could use range-over-integer"
+		fmt.Println(j)
+	}
+	for j := uint8(0); j < N; j++ { // want "could use range-over-integer"
+		fmt.Println(j)
+	}
+	for j := myInt(0); j < 3; j++ { // want "could use range-over-integer"
+		fmt.Println(j)
+	}
+	for j := tm.Duration(0); j < 3; j++ { // want "could use range-over-integer"
+		fmt.Println(j)
+	}
+	for j := int64(0); j < 5; j++ { // want "could use range-over-integer"
+		fmt.Println("hello")
+	}
+	for j := float64(0); j < 5; j++ {
+		fmt.Println(j)
+	}
+	// An untyped float constant can't be ranged over, even if it's a
+	// whole number.
+	for i := 0; i < 1e6; i++ {
+		fmt.Println(i)
+	}
+	// Ranging over a rune constant would make the loop var a rune.
+	const c = 'a'
+	for i := 0; i < c; i++ { // want "could use range-over-integer"
+		fmt.Println(i)
+	}
+
+	// Non-constant bounds aren't reported without -nonconst.
+	for i := 0; i < n; i++ {
+		fmt.Println(i)
+	}
+	for i := 0; i < len(xs); i++ {
+		fmt.Println(xs[i])
+	}
+
+	// Other loop shapes aren't reported.
+	for i := 1; i < 10; i++ {
+		fmt.Println(i)
+	}
+	for i := 0; i < 10; {
+		i++
+	}
+	var k int
+	for k = 0; k < 10; k++ {
+		fmt.Println(k)
+	}
+	for i, j := 0, 0; i < 10; i++ {
+		fmt.Println(i, j)
+	}
+}
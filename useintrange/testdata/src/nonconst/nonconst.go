@@ -0,0 +1,38 @@
+package nonconst
+
+import "fmt"
+
+func f(xs []int, n int, u uint, n32 int32) {
+	for i := 0; i < n; i++ { // want `could possibly use range-over-integer \(non-constant\)`
+		fmt.Println(i)
+	}
+	for i := 0; i < len(xs); i++ { // want `could possibly use range-over-integer \(non-constant\)`
+		fmt.Println(xs[i])
+	}
+	for i := 0; i < len(xs); i++ { // want `could possibly use range-over-integer \(non-constant\)`
+		fmt.Println("hello")
+	}
+	for i := 0; i < 10; i++ { // want "could use range-over-integer"
+		fmt.Println(i)
+	}
+	for i := 0; i <= n-1; i++ { // want `could possibly use range-over-integer \(non-constant\)`
+		fmt.Println(i)
+	}
+	for i := int32(0); len(xs) > int(i); i++ {
+		fmt.Println(i)
+	}
+	for i := int32(0); i < n32; i++ { // want `could possibly use range-over-integer \(non-constant\)`
+		fmt.Println(i)
+	}
+
+	// With an unsigned bound, N-1 wraps around if N is 0.
+	for i := uint(0); i <= u-1; i++ {
+		fmt.Println(i)
+	}
+	for i := uint(0); i < u; i++ { // want `could possibly use range-over-integer \(non-constant\)`
+		fmt.Println(i)
+	}
+	for i := uint(0); i <= uint(3)-1; i++ { // want "could use range-over-integer"
+		fmt.Println(i)
+	}
+}
@@ -0,0 +1,158 @@
+package sound
+
+import "fmt"
+
+var global = 10
+
+type counter int
+
+func (c *counter) inc() { *c++ }
+
+type buf struct {
+	xs []int
+	n  int
+}
+
+func (b *buf) add(x int) { b.xs = append(b.xs, x) }
+
+func use(...any) {}
+
+func loopVar() {
+	for i := 0; i < 10; i++ { // want `cannot use range-over-integer: loop body assigns to i`
+		if i == 3 {
+			i += 2
+		}
+	}
+	for i := 0; i < 10; i++ { // want `cannot use range-over-integer: loop body assigns to i`
+		func() { i = 9 }()
+	}
+	for i := 0; i < 10; i++ { // want `cannot use range-over-integer: loop body takes the address of i`
+		use(&i)
+	}
+	for i := counter(0); i < 10; i++ { // want `cannot use range-over-integer: loop body takes the address of i`
+		i.inc()
+	}
+	for i := 0; i < 10; i++ { // want `cannot use range-over-integer: loop body assigns to i`
+		for i = range 3 {
+		}
+	}
+	// Shadowing i is fine.
+	for i := 0; i < 10; i++ { // want "could use range-over-integer"
+		i := i * 2
+		i++
+		use(&i)
+	}
+}
+
+func bounds(xs []int, m map[int]int, ch chan int, b *buf, n int) {
+	for i := 0; i < n; i++ { // want `cannot use range-over-integer: loop body modifies n, which the bound reads`
+		if xs[i] < 0 {
+			n--
+		}
+	}
+	for i := 0; i < len(xs); i++ { // want `cannot use range-over-integer: loop body modifies xs, which the bound reads`
+		if xs[i] == 0 {
+			xs = append(xs, 1)
+		}
+	}
+	for i := 0; i < b.n; i++ { // want `cannot use range-over-integer: loop body modifies b, which the bound reads`
+		b.n = 3
+	}
+	for i := 0; i < len(b.xs); i++ { // want `cannot use range-over-integer: loop body calls functions that may modify the memory that the bound reads`
+		b.add(i)
+	}
+	for i := 0; i < len(m); i++ { // want `cannot use range-over-integer: loop body modifies m, which the bound reads`
+		delete(m, i)
+	}
+	for i := 0; i < global; i++ { // want `cannot use range-over-integer: loop body calls functions that may modify global, which the bound reads`
+		fmt.Println(i)
+	}
+	for i := 0; i < len(ch); i++ { // want `cannot use range-over-integer: bound calls len on a channel`
+		use(i)
+	}
+	for i := 0; i < <-ch; i++ { // want `cannot use range-over-integer: bound receives from a channel`
+		use(i)
+	}
+	for i := 0; i < count(); i++ { // want `cannot use range-over-integer: bound calls count, which may have side effects`
+		use(i)
+	}
+	for i := 0; i < n; i++ { // want `cannot use range-over-integer: loop body modifies n, which the bound reads`
+		inc := func() { n++ }
+		inc()
+	}
+}
+
+func escapes(xs []int, n, k int) {
+	for i := 0; i < len(xs); i++ { // want `cannot use range-over-integer: loop body takes the address of xs, which the bound reads`
+		use(&xs)
+	}
+	inc := func() { n++ }
+	for i := 0; i < n; i++ { // want `cannot use range-over-integer: loop body calls functions that may modify n, which the bound reads`
+		inc()
+	}
+	p := &k
+	for i := 0; i < k; i++ { // want `cannot use range-over-integer: loop body calls functions that may modify k, which the bound reads`
+		use(i, p)
+	}
+}
+
+func pointerWrites(n, m int) {
+	p := &n
+	for i := 0; i < n; i++ { // want `cannot use range-over-integer: loop body modifies \*p, which may alias the bound`
+		*p = 0
+	}
+	// m's address is taken, but a string can't alias it.
+	use(&m)
+	var s string
+	ps := &s
+	for i := 0; i < m; i++ { // want `could possibly use range-over-integer \(non-constant\)`
+		*ps = ""
+	}
+}
+
+// Writes through other variables change the bound if they alias what it
+// reads.
+func aliases(b, c *buf, xs, ys []int, m, m2 map[int]int, p *int, k int) {
+	for i := 0; i < b.n; i++ { // want `cannot use range-over-integer: loop body modifies c.n, which may alias the bound`
+		c.n = 0
+	}
+	for i := 0; i < xs[0]; i++ { // want `cannot use range-over-integer: loop body modifies ys\[0\], which may alias the bound`
+		ys[0] = 0
+	}
+	for i := 0; i < b.n; i++ { // want `cannot use range-over-integer: loop body modifies \*c, which may alias the bound`
+		*c = buf{}
+	}
+	for i := 0; i < len(m); i++ { // want `cannot use range-over-integer: loop body modifies m2, which may alias the bound`
+		delete(m2, i)
+	}
+	use(&k)
+	for i := 0; i < *p; i++ { // want `cannot use range-over-integer: loop body modifies k, which may alias the bound`
+		k = 0
+	}
+	// A field never overlaps a slice element or a different field.
+	for i := 0; i < b.n; i++ { // want `could possibly use range-over-integer \(non-constant\)`
+		c.xs = nil
+		ys[0] = 0
+	}
+}
+
+// These are fine: the bound can't change.
+func fine(xs []int, b *buf) {
+	for i := 0; i < len(xs); i++ { // want `could possibly use range-over-integer \(non-constant\)`
+		xs[i] = 0
+		clear(xs)
+		use(i)
+	}
+	for i := 0; i < b.n; i++ { // want `could possibly use range-over-integer \(non-constant\)`
+		xs[i] = 1
+	}
+	for i := 0; i < min(len(xs), 5); i++ { // want `could possibly use range-over-integer \(non-constant\)`
+		fmt.Println(xs[i])
+	}
+	k := len(xs)
+	for i := 0; i < k; i++ { // want `could possibly use range-over-integer \(non-constant\)`
+		fmt.Println(i)
+	}
+}
+
+func count() int { return 3 }
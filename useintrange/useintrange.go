@@ -1,114 +1,113 @@
 package main
 
 import (
-	"flag"
 	"fmt"
 	"go/ast"
+	"go/constant"
 	"go/token"
 	"go/types"
-	"log"
-	"os"
+	"go/version"
 	"strconv"
 
-	"golang.org/x/tools/go/packages"
-	"rsc.io/edit"
+	"golang.org/x/tools/go/analysis"
+	"golang.org/x/tools/go/analysis/singlechecker"
 )
 
+var Analyzer = &analysis.Analyzer{
+	Name: "useintrange",
+	Doc:  "Find 3-clause for loops that could range over an integer (Go >= 1.22)",
+	Run:  run,
+}
+
 var (
-	verbose  = flag.Bool("v", false, "Operate in verbose mode")
-	fix      = flag.Bool("fix", false, "Fix the code instead of printing warnings")
-	nonConst = flag.Bool("nonconst", false, "Include non-constant range vars (lots of false positives!)")
+	nonConst bool
+	verbose  bool
 )
 
-func main() {
-	log.SetFlags(0)
-	flag.Parse()
-
-	cfg := &packages.Config{
-		Mode: packages.NeedName |
-			packages.NeedCompiledGoFiles |
-			packages.NeedTypes |
-			packages.NeedTypesInfo |
-			packages.NeedSyntax,
-	}
-	pkgs, err := packages.Load(cfg, flag.Args()...)
-	if err != nil {
-		log.Fatal(err)
-	}
-	if packages.PrintErrors(pkgs) > 0 {
-		os.Exit(1)
-	}
-	var numFixes, numFiles int
-	for _, pkg := range pkgs {
-		if *verbose {
-			log.Printf("Processing %s...", pkg.PkgPath)
-		}
-		nfx, nfi := fixPackage(pkg)
-		numFixes += nfx
-		numFiles += nfi
-	}
-	if *fix {
-		log.Printf("Fixed %d for statements across %d files", numFixes, numFiles)
-	}
+func init() {
+	Analyzer.Flags.BoolVar(&nonConst, "nonconst", false, "Include non-constant range vars (lots of false positives!)")
+	Analyzer.Flags.BoolVar(&verbose, "verbose", false, "Also report loops that can't be rewritten, and why")
 }
 
-func fixPackage(pkg *packages.Package) (numFixes, numFiles int) {
-	if len(pkg.CompiledGoFiles) != len(pkg.Syntax) {
-		log.Fatalf(
-			"len(CompiledGoFiles)=%d; len(Syntax)=%d",
-			len(pkg.CompiledGoFiles), len(pkg.Syntax),
-		)
-	}
-	for i, file := range pkg.Syntax {
-		name := pkg.CompiledGoFiles[i]
-		targets := locateTargets(pkg, file)
-		if len(targets) == 0 {
-			continue
-		}
-		numFixes += len(targets)
-		numFiles++
-		if *verbose {
-			log.Printf("% 4d %s", len(targets), name)
-		}
-		if *fix {
-			if err := fixTargets(pkg, name, targets); err != nil {
-				log.Fatalf("Error editing %s: %s", name, err)
+func run(pass *analysis.Pass) (any, error) {
+	for _, file := range pass.Files {
+		for _, targ := range locateTargets(pass, file) {
+			if !targ.constant && !nonConst {
+				continue
 			}
-		} else {
-			for _, target := range targets {
-				pos := pkg.Fset.Position(target.stmt.For)
-				if target.constant {
-					fmt.Printf("%s: could use range-over-integer\n", pos)
-				} else {
-					fmt.Printf("%s: could possibly use range-over-integer (non-constant)\n", pos)
+			if targ.declined != "" {
+				if verbose {
+					pass.Reportf(targ.stmt.For, "cannot use range-over-integer: %s", targ.declined)
 				}
+				continue
+			}
+			msg := "could use range-over-integer"
+			if !targ.constant {
+				msg = "could possibly use range-over-integer (non-constant)"
 			}
+			pass.Report(analysis.Diagnostic{
+				Pos:            targ.stmt.For,
+				End:            targ.stmt.Body.Lbrace,
+				Message:        msg,
+				SuggestedFixes: []analysis.SuggestedFix{targ.fix()},
+			})
 		}
 	}
-	return numFixes, numFiles
+	return nil, nil
 }
 
 type target struct {
 	stmt        *ast.ForStmt
+	bound       ast.Expr // the N in 'range N'
+	conv        string   // if non-empty, the type to which to convert N
 	constant    bool
 	bodyUsesVar bool
+	// declined, if set, is the reason that the loop can't be rewritten.
+	declined string
 }
 
-func locateTargets(pkg *packages.Package, file *ast.File) []target {
+func locateTargets(pass *analysis.Pass, file *ast.File) []target {
 	var targets []target
+	ff := gatherFileFacts(pass, file)
+	var tooOld string
+	if v := fileVersion(pass, file); v != "" && version.Compare(v, minVersion) < 0 {
+		tooOld = fmt.Sprintf("file's Go version is %s (range-over-integer requires %s)", v, minVersion)
+	}
 	ast.Inspect(file, func(n ast.Node) bool {
 		stmt, ok := n.(*ast.ForStmt)
 		if !ok {
 			return true
 		}
-		ident, constant := stmtCanUseRange(pkg, stmt)
+		ident, bound := stmtCanUseRange(pass, stmt)
 		if ident == nil {
 			return true
 		}
+		rangeTyp, ok := rangeType(pass, bound)
+		if !ok {
+			return true
+		}
 		targ := target{
 			stmt:        stmt,
-			constant:    constant,
-			bodyUsesVar: bodyUses(pkg, stmt.Body, ident),
+			bound:       bound,
+			constant:    pass.TypesInfo.Types[bound].Value != nil,
+			bodyUsesVar: bodyUses(pass, stmt.Body, ident),
+		}
+		if tooOld != "" {
+			targ.declined = tooOld
+		} else {
+			targ.declined = checkSound(pass, ff, stmt, ident, bound)
+		}
+		if targ.bodyUsesVar {
+			// The loop var takes its type from N, so N may need to be
+			// converted to keep the loop var's type the same.
+			varType := pass.TypesInfo.Defs[ident].Type()
+			if !types.Identical(rangeTyp, varType) {
+				conv, ok := typeString(pass, file, varType)
+				if !ok {
+					return true
+				}
+				targ.conv = conv
+			}
 		}
 		targets = append(targets, targ)
 		return true
@@ -116,27 +115,41 @@ func locateTargets(pkg *packages.Package, file *ast.File) []target {
 	return targets
 }
 
-func stmtCanUseRange(pkg *packages.Package, stmt *ast.ForStmt) (ident *ast.Ident, constant bool) {
+// minVersion is the first Go version that supports ranging over an
+// integer.
+const minVersion = "go1.22"
+
+// fileVersion gives the Go language version that applies to file (as set
+// by go.mod and any //go:build constraint), or "" if it is unknown.
+func fileVersion(pass *analysis.Pass, file *ast.File) string {
+	if v, ok := pass.TypesInfo.FileVersions[file]; ok {
+		return v
+	}
+	return pass.Pkg.GoVersion()
+}
+
+// stmtCanUseRange reports whether stmt is a loop that counts from 0 to N-1
+// by 1s. If so, it returns the loop var and N.
+func stmtCanUseRange(pass *analysis.Pass, stmt *ast.ForStmt) (ident *ast.Ident, bound ast.Expr) {
 	if stmt.Init == nil || stmt.Cond == nil || stmt.Post == nil {
-		return nil, false
+		return nil, nil
 	}
-	ident = initIsSimpleDecl(pkg, stmt.Init)
+	ident = initIsSimpleDecl(pass, stmt.Init)
 	if ident == nil {
-		return nil, false
+		return nil, nil
 	}
-	obj := pkg.TypesInfo.Defs[ident]
-	var simpleLessThan bool
-	simpleLessThan, constant = checkCond(pkg, stmt.Cond, obj)
-	if !simpleLessThan {
-		return nil, false
+	obj := pass.TypesInfo.Defs[ident]
+	bound = condBound(pass, stmt.Cond, obj)
+	if bound == nil {
+		return nil, nil
 	}
-	if !postIsIncrement(pkg, stmt.Post, obj) {
-		return nil, false
+	if !postIsIncrement(pass, stmt.Post, obj) {
+		return nil, nil
 	}
-	return ident, constant
+	return ident, bound
 }
 
-func initIsSimpleDecl(pkg *packages.Package, init ast.Stmt) *ast.Ident {
+func initIsSimpleDecl(pass *analysis.Pass, init ast.Stmt) *ast.Ident {
 	assn, ok := init.(*ast.AssignStmt)
 	if !ok {
 		return nil
@@ -151,13 +164,13 @@ func initIsSimpleDecl(pkg *packages.Package, init ast.Stmt) *ast.Ident {
 	if !ok {
 		return nil
 	}
-	if !isInt(pkg, assn.Rhs[0], 0) {
+	if !isInt(pass, assn.Rhs[0], 0) {
 		return nil
 	}
 	return ident
 }
 
-func isInt(pkg *packages.Package, expr ast.Expr, val int64) bool {
+func isInt(pass *analysis.Pass, expr ast.Expr, val int64) bool {
 	switch expr := expr.(type) {
 	case *ast.BasicLit:
 		return isLiteralInt(expr, val)
@@ -173,12 +186,13 @@ func isInt(pkg *packages.Package, expr ast.Expr, val int64) bool {
 			return false
 		}
 
-		ident, ok := expr.Fun.(*ast.Ident)
-		if !ok {
+		// Check that this is a conversion (to a possibly qualified
+		// type name, as in int64(0) or time.Duration(0)).
+		fun := pass.TypesInfo.Types[expr.Fun]
+		if !fun.IsType() {
 			return false
 		}
-		typ := pkg.TypesInfo.Uses[ident].Type().Underlying()
-		basic, ok := typ.(*types.Basic)
+		basic, ok := fun.Type.Underlying().(*types.Basic)
 		if !ok {
 			return false
 		}
@@ -194,73 +208,179 @@ func isLiteralInt(lit *ast.BasicLit, val int64) bool {
 	if lit.Kind != token.INT {
 		return false
 	}
-	n, err := strconv.ParseInt(lit.Value, 10, 64)
+	// Base 0 handles prefixes (0x, 0o, 0b) and underscores.
+	n, err := strconv.ParseInt(lit.Value, 0, 64)
 	if err != nil {
 		return false
 	}
 	return n == val
 }
 
-func checkCond(pkg *packages.Package, expr ast.Expr, varObj types.Object) (simpleLessThan, constant bool) {
+// condBound checks whether expr is a loop condition of one of the forms
+//
+//	i < N
+//	N > i
+//	i <= N-1
+//	N-1 >= i
+//
+// where i is the loop var. If so, it returns N.
+func condBound(pass *analysis.Pass, expr ast.Expr, varObj types.Object) ast.Expr {
 	bin, ok := expr.(*ast.BinaryExpr)
 	if !ok {
-		return false, false
+		return nil
 	}
-	if bin.Op != token.LSS {
-		return false, false
+	x, op, y := bin.X, bin.Op, bin.Y
+	switch op {
+	case token.GTR:
+		x, op, y = y, token.LSS, x
+	case token.GEQ:
+		x, op, y = y, token.LEQ, x
 	}
-	if !isMatchingIdent(pkg, bin.X, varObj) {
-		return false, false
+	if !isMatchingIdent(pass, x, varObj) {
+		return nil
 	}
-	if pkg.TypesInfo.Types[bin.Y].Value != nil {
-		return true, true
+	switch op {
+	case token.LSS:
+		return y
+	case token.LEQ:
+		sub, ok := y.(*ast.BinaryExpr)
+		if !ok || sub.Op != token.SUB {
+			return nil
+		}
+		lit, ok := sub.Y.(*ast.BasicLit)
+		if !ok || !isLiteralInt(lit, 1) {
+			return nil
+		}
+		// If N is unsigned, N-1 wraps around when N is 0, so
+		// i <= N-1 is only the same as i < N if N is a positive
+		// constant.
+		if isUnsigned(pass.TypesInfo.TypeOf(sub.X)) {
+			v := pass.TypesInfo.Types[sub.X].Value
+			if v == nil || constant.Sign(v) <= 0 {
+				return nil
+			}
+		}
+		return sub.X
 	}
-	return true, false
+	return nil
 }
 
-func postIsIncrement(pkg *packages.Package, stmt ast.Stmt, varObj types.Object) bool {
+func postIsIncrement(pass *analysis.Pass, stmt ast.Stmt, varObj types.Object) bool {
 	switch stmt := stmt.(type) {
 	case *ast.AssignStmt:
 		if len(stmt.Lhs) != 1 || len(stmt.Rhs) != 1 {
 			return false
 		}
-		if !isMatchingIdent(pkg, stmt.Lhs[0], varObj) {
+		if !isMatchingIdent(pass, stmt.Lhs[0], varObj) {
 			return false
 		}
-		bin, ok := stmt.Rhs[0].(*ast.BinaryExpr)
-		if !ok {
-			return false
-		}
-		if bin.Op != token.ADD {
-			return false
-		}
-		if !isMatchingIdent(pkg, bin.X, varObj) {
-			return false
-		}
-		basic, ok := bin.Y.(*ast.BasicLit)
-		if !ok {
-			return false
+		switch stmt.Tok {
+		case token.ADD_ASSIGN:
+			// i += 1
+			basic, ok := stmt.Rhs[0].(*ast.BasicLit)
+			return ok && isLiteralInt(basic, 1)
+		case token.ASSIGN:
+			// i = i + 1
+			bin, ok := stmt.Rhs[0].(*ast.BinaryExpr)
+			if !ok {
+				return false
+			}
+			if bin.Op != token.ADD {
+				return false
+			}
+			if !isMatchingIdent(pass, bin.X, varObj) {
+				return false
+			}
+			basic, ok := bin.Y.(*ast.BasicLit)
+			if !ok {
+				return false
+			}
+			return isLiteralInt(basic, 1)
 		}
-		return isLiteralInt(basic, 1)
 	case *ast.IncDecStmt:
 		if stmt.Tok != token.INC {
 			return false
 		}
-		return isMatchingIdent(pkg, stmt.X, varObj)
+		return isMatchingIdent(pass, stmt.X, varObj)
 	}
 	return false
 }
 
-func isMatchingIdent(pkg *packages.Package, expr ast.Expr, obj types.Object) bool {
+func isUnsigned(typ types.Type) bool {
+	basic, ok := typ.Underlying().(*types.Basic)
+	return ok && basic.Info()&types.IsUnsigned != 0
+}
+
+// rangeType gives the type of the loop var in 'for i := range bound'. It
+// returns false if bound can't be ranged over: an untyped constant must
+// be an integer or rune constant (1e6, say, is an untyped float).
+func rangeType(pass *analysis.Pass, bound ast.Expr) (types.Type, bool) {
+	typ := pass.TypesInfo.TypeOf(bound)
+	if pass.TypesInfo.Types[bound].Value == nil {
+		return typ, true
+	}
+	// The type checker records the type that a constant bound was
+	// converted to in the loop condition (for instance, int64 in
+	// i < 5 if i is an int64). Check the bound by itself to see
+	// whether it is untyped, in which case the loop var has the
+	// constant's default type.
+	info := &types.Info{Types: make(map[ast.Expr]types.TypeAndValue)}
+	if err := types.CheckExpr(pass.Fset, pass.Pkg, bound.Pos(), bound, info); err != nil {
+		return typ, true
+	}
+	if basic, ok := info.Types[bound].Type.(*types.Basic); ok && basic.Info()&types.IsUntyped != 0 {
+		switch basic.Kind() {
+		case types.UntypedInt:
+			return types.Typ[types.Int], true
+		case types.UntypedRune:
+			return types.Universe.Lookup("rune").Type(), true
+		}
+		return nil, false
+	}
+	return typ, true
+}
+
+// typeString gives the source for typ as it may be written in file. It
+// returns false if that's not possible (for instance, if typ is declared
+// in a package that file doesn't import).
+func typeString(pass *analysis.Pass, file *ast.File, typ types.Type) (string, bool) {
+	ok := true
+	s := types.TypeString(typ, func(p *types.Package) string {
+		if p == pass.Pkg {
+			return ""
+		}
+		for _, imp := range file.Imports {
+			path, err := strconv.Unquote(imp.Path.Value)
+			if err != nil || path != p.Path() {
+				continue
+			}
+			if imp.Name != nil {
+				switch imp.Name.Name {
+				case "_":
+					continue
+				case ".":
+					return ""
+				}
+				return imp.Name.Name
+			}
+			return p.Name()
+		}
+		ok = false
+		return ""
+	})
+	return s, ok
+}
+
+func isMatchingIdent(pass *analysis.Pass, expr ast.Expr, obj types.Object) bool {
 	ident, ok := expr.(*ast.Ident)
 	if !ok {
 		return false
 	}
-	return pkg.TypesInfo.Uses[ident] == obj
+	return pass.TypesInfo.Uses[ident] == obj
 }
 
-func bodyUses(pkg *packages.Package, body *ast.BlockStmt, ident *ast.Ident) bool {
-	obj := pkg.TypesInfo.Defs[ident]
+func bodyUses(pass *analysis.Pass, body *ast.BlockStmt, ident *ast.Ident) bool {
+	obj := pass.TypesInfo.Defs[ident]
 	if obj == nil {
 		panic("bad")
 	}
@@ -271,7 +391,7 @@ func bodyUses(pkg *packages.Package, body *ast.BlockStmt, ident *ast.Ident) bool
 			if !ok {
 				return true
 			}
-			if pkg.TypesInfo.Uses[ident] == obj {
+			if pass.TypesInfo.Uses[ident] == obj {
 				seen = true
 			}
 			return true
@@ -283,43 +403,37 @@ func bodyUses(pkg *packages.Package, body *ast.BlockStmt, ident *ast.Ident) bool
 	return false
 }
 
-func fixTargets(pkg *packages.Package, filename string, targets []target) error {
-	b, err := os.ReadFile(filename)
-	if err != nil {
-		return err
+func (targ target) fix() analysis.SuggestedFix {
+	var start token.Pos
+	var msg string
+	if targ.bodyUsesVar {
+		// Convert
+		//   for i := 0; i < N; i++ {
+		// to
+		//   for i := range N {
+		start = targ.stmt.Init.(*ast.AssignStmt).Rhs[0].Pos()
+		msg = "Replace with 'for i := range N'"
+	} else {
+		// Convert
+		//   for i := 0; i < N; i++ {
+		// to
+		//   for range N {
+		start = targ.stmt.Init.Pos()
+		msg = "Replace with 'for range N'"
 	}
-
-	buf := edit.NewBuffer(b)
-	for _, targ := range targets {
-		if targ.bodyUsesVar {
-			// Convert
-			//   for i := 0; i < N; i++ {
-			// to
-			//   for i := range N {
-			initRHS := targ.stmt.Init.(*ast.AssignStmt).Rhs[0]
-			initRHSPos := pkg.Fset.Position(initRHS.Pos())
-			condRHS := targ.stmt.Cond.(*ast.BinaryExpr).Y
-			condRHSPos := pkg.Fset.Position(condRHS.Pos())
-			buf.Replace(initRHSPos.Offset, condRHSPos.Offset, "range ")
-
-			condRHSPosEnd := pkg.Fset.Position(condRHS.End())
-			openBracePos := pkg.Fset.Position(targ.stmt.Body.Lbrace)
-			buf.Replace(condRHSPosEnd.Offset, openBracePos.Offset, " ")
-		} else {
-			// Convert
-			//   for i := 0; i < N; i++ {
-			// to
-			//   for range N {
-			initPos := pkg.Fset.Position(targ.stmt.Init.Pos())
-			condRHS := targ.stmt.Cond.(*ast.BinaryExpr).Y
-			condRHSPos := pkg.Fset.Position(condRHS.Pos())
-			buf.Replace(initPos.Offset, condRHSPos.Offset, "range ")
-
-			condRHSPosEnd := pkg.Fset.Position(condRHS.End())
-			openBracePos := pkg.Fset.Position(targ.stmt.Body.Lbrace)
-			buf.Replace(condRHSPosEnd.Offset, openBracePos.Offset, " ")
-		}
+	prefix, suffix := "range ", " "
+	if targ.conv != "" {
+		prefix, suffix = "range "+targ.conv+"(", ") "
+	}
+	return analysis.SuggestedFix{
+		Message: msg,
+		TextEdits: []analysis.TextEdit{
+			{Pos: start, End: targ.bound.Pos(), NewText: []byte(prefix)},
+			{Pos: targ.bound.End(), End: targ.stmt.Body.Lbrace, NewText: []byte(suffix)},
+		},
 	}
+}
 
-	return os.WriteFile(filename, buf.Bytes(), 0o644)
+func main() {
+	singlechecker.Main(Analyzer)
 }
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/tools/go/analysis/analysistest"
+)
+
+func TestAnalyzer(t *testing.T) {
+	analysistest.RunWithSuggestedFixes(t, analysistest.TestData(), Analyzer, "a")
+}
+
+func TestNonConst(t *testing.T) {
+	setFlags(t, "nonconst")
+	analysistest.RunWithSuggestedFixes(t, analysistest.TestData(), Analyzer, "nonconst")
+}
+
+func TestSoundness(t *testing.T) {
+	setFlags(t, "nonconst", "verbose")
+	analysistest.RunWithSuggestedFixes(t, analysistest.TestData(), Analyzer, "sound")
+}
+
+func TestOldGoVersion(t *testing.T) {
+	setFlags(t, "verbose")
+	analysistest.Run(t, filepath.Join(analysistest.TestData(), "go121"), Analyzer, "./...")
+}
+
+func TestMixedGoVersions(t *testing.T) {
+	analysistest.RunWithSuggestedFixes(t, filepath.Join(analysistest.TestData(), "mixed"), Analyzer, "./...")
+}
+
+// setFlags sets the named boolean analyzer flags for the duration of the
+// test.
+func setFlags(t *testing.T, names ...string) {
+	t.Helper()
+	for _, name := range names {
+		if err := Analyzer.Flags.Set(name, "true"); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { Analyzer.Flags.Set(name, "false") })
+	}
+}
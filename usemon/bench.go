@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/cespare/misc/usemon/procmon"
+	"github.com/dustin/go-humanize"
+)
+
+// bench implements the bench subcommand, which runs a command repeatedly
+// and summarizes its resource usage. Given two commands, it interleaves
+// their runs and compares them.
+func bench(args []string) {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	var (
+		n          = fs.Int("n", 10, "number of measured runs (per command)")
+		warmup     = fs.Int("warmup", 0, "number of unmeasured warmup runs (per command)")
+		sep        = fs.String("sep", "--", "argument that separates the two commands in A/B mode")
+		showOutput = fs.Bool("show-output", false, "show the commands' stdout and stderr")
+	)
+	mf := addMonitorFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, `usage: %[1]s bench [flags] -- command [args...]
+       %[1]s bench [flags] -- commandA [args...] -- commandB [args...]
+
+Flags:
+`, os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if *n < 2 || *warmup < 0 {
+		log.Fatal("-n must be at least 2 and -warmup must be non-negative")
+	}
+	opts, err := mf.options()
+	if err != nil {
+		log.Fatal(err)
+	}
+	cmds := splitCommands(fs.Args(), *sep)
+	if len(cmds) == 0 || len(cmds) > 2 || slices.ContainsFunc(cmds, func(c []string) bool { return len(c) == 0 }) {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	b := &benchmarker{opts: opts, showOutput: *showOutput}
+	results := make([][]*procmon.Stats, len(cmds))
+	total := *warmup + *n
+	for i := range total {
+		// Interleave the commands so that they see similar conditions.
+		for j, cmd := range cmds {
+			stats, err := b.run(cmd)
+			if err != nil {
+				log.Printf("Run %d of %q failed", i+1, strings.Join(cmd, " "))
+				exitWithError(err)
+			}
+			if i >= *warmup {
+				results[j] = append(results[j], stats)
+			}
+		}
+		fmt.Fprintf(os.Stderr, "\rCompleted %d/%d runs", i+1, total)
+	}
+	fmt.Fprintln(os.Stderr)
+
+	if len(cmds) == 1 {
+		printSummary(os.Stdout, results[0])
+	} else {
+		printComparison(os.Stdout, results[0], results[1])
+	}
+}
+
+// splitCommands splits args into commands at each sep.
+func splitCommands(args []string, sep string) [][]string {
+	if len(args) == 0 {
+		return nil
+	}
+	var cmds [][]string
+	for {
+		i := slices.Index(args, sep)
+		if i < 0 {
+			return append(cmds, args)
+		}
+		cmds = append(cmds, args[:i])
+		args = args[i+1:]
+	}
+}
+
+type benchmarker struct {
+	opts       procmon.Options
+	showOutput bool
+}
+
+func (b *benchmarker) run(args []string) (*procmon.Stats, error) {
+	cmd := exec.Command(args[0], args[1:]...)
+	if b.showOutput {
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+	}
+	return procmon.Run(context.Background(), cmd, b.opts)
+}
+
+// A metric is a measurement taken from each run.
+type metric struct {
+	name   string
+	get    func(*procmon.Stats) float64
+	format func(float64) string
+}
+
+var metrics = []metric{
+	{"elapsed", func(s *procmon.Stats) float64 { return s.Elapsed.Seconds() }, formatSeconds},
+	{"cpu", func(s *procmon.Stats) float64 { return s.CPU().Seconds() }, formatSeconds},
+	{"max RSS", func(s *procmon.Stats) float64 { return float64(s.PeakRSS) }, formatBytes},
+}
+
+func formatSeconds(x float64) string {
+	d := time.Duration(x * float64(time.Second))
+	switch {
+	case d >= time.Second:
+		return d.Round(time.Millisecond).String()
+	case d >= time.Millisecond:
+		return d.Round(10 * time.Microsecond).String()
+	default:
+		return d.String()
+	}
+}
+
+func formatBytes(x float64) string {
+	return humanize.Bytes(uint64(math.Max(x, 0)))
+}
+
+func (m *metric) values(stats []*procmon.Stats) []float64 {
+	xs := make([]float64, len(stats))
+	for i, s := range stats {
+		xs[i] = m.get(s)
+	}
+	return xs
+}
+
+func printSummary(w io.Writer, stats []*procmon.Stats) {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "\tmean\tstddev\tmin\tmax\t")
+	for _, m := range metrics {
+		s := summarize(m.values(stats))
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t\n",
+			m.name, m.format(s.mean), m.format(s.stddev), m.format(s.min), m.format(s.max))
+	}
+	tw.Flush()
+}
+
+func printComparison(w io.Writer, a, b []*procmon.Stats) {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "\tA mean\t± stddev\tB mean\t± stddev\tB vs A\t95% CI\t")
+	for _, m := range metrics {
+		sa, sb := summarize(m.values(a)), summarize(m.values(b))
+		delta, ci := "~", "~"
+		if sa.mean != 0 {
+			lo, hi := welchInterval(sa, sb)
+			delta = formatPercent((sb.mean - sa.mean) / sa.mean)
+			ci = fmt.Sprintf("[%s, %s]", formatPercent(lo/sa.mean), formatPercent(hi/sa.mean))
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t\n",
+			m.name,
+			m.format(sa.mean), m.format(sa.stddev),
+			m.format(sb.mean), m.format(sb.stddev),
+			delta, ci)
+	}
+	tw.Flush()
+	fmt.Fprintln(w, "(The intervals are for the difference of the means, relative to A's mean.)")
+}
+
+func formatPercent(x float64) string {
+	return fmt.Sprintf("%+.1f%%", 100*x)
+}
+
+type summary struct {
+	n        int
+	mean     float64
+	stddev   float64 // sample standard deviation
+	min, max float64
+}
+
+func summarize(xs []float64) summary {
+	s := summary{
+		n:   len(xs),
+		min: slices.Min(xs),
+		max: slices.Max(xs),
+	}
+	for _, x := range xs {
+		s.mean += x
+	}
+	s.mean /= float64(len(xs))
+	if len(xs) > 1 {
+		var ss float64
+		for _, x := range xs {
+			ss += (x - s.mean) * (x - s.mean)
+		}
+		s.stddev = math.Sqrt(ss / float64(len(xs)-1))
+	}
+	return s
+}
+
+// welchInterval returns a 95% confidence interval for b.mean - a.mean
+// using Welch's t-test (which doesn't assume equal variances).
+func welchInterval(a, b summary) (lo, hi float64) {
+	va := a.stddev * a.stddev / float64(a.n)
+	vb := b.stddev * b.stddev / float64(b.n)
+	se := math.Sqrt(va + vb)
+	diff := b.mean - a.mean
+	if se == 0 {
+		return diff, diff
+	}
+	// Welch–Satterthwaite degrees of freedom.
+	df := (va + vb) * (va + vb) /
+		(va*va/float64(a.n-1) + vb*vb/float64(b.n-1))
+	t := tQuantile975(df)
+	return diff - t*se, diff + t*se
+}
+
+// tTable975 holds the 0.975 quantiles of Student's t-distribution for 1
+// through 30 degrees of freedom.
+var tTable975 = []float64{
+	12.706, 4.303, 3.182, 2.776, 2.571, 2.447, 2.365, 2.306, 2.262, 2.228,
+	2.201, 2.179, 2.160, 2.145, 2.131, 2.120, 2.110, 2.101, 2.093, 2.086,
+	2.080, 2.074, 2.069, 2.064, 2.060, 2.056, 2.052, 2.048, 2.045, 2.042,
+}
+
+// tQuantile975 returns (approximately) the 0.975 quantile of Student's
+// t-distribution with df degrees of freedom.
+func tQuantile975(df float64) float64 {
+	if df < 1 {
+		df = 1
+	}
+	if df <= float64(len(tTable975)) {
+		// Interpolate between the integer degrees of freedom.
+		i := int(df)
+		if i == len(tTable975) {
+			return tTable975[i-1]
+		}
+		frac := df - float64(i)
+		return tTable975[i-1]*(1-frac) + tTable975[i]*frac
+	}
+	// For larger df, use the first terms of the Cornish–Fisher
+	// expansion around the normal quantile.
+	const z = 1.959964
+	return z + (z*z*z+z)/(4*df) + (5*math.Pow(z, 5)+16*z*z*z+3*z)/(96*df*df)
+}
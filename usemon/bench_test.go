@@ -0,0 +1,68 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestSplitCommands(t *testing.T) {
+	for _, tt := range []struct {
+		args []string
+		want [][]string
+	}{
+		{nil, nil},
+		{[]string{"make"}, [][]string{{"make"}}},
+		{[]string{"./a", "x", "--", "./b", "y"}, [][]string{{"./a", "x"}, {"./b", "y"}}},
+		{[]string{"./a", "--"}, [][]string{{"./a"}, {}}},
+	} {
+		got := splitCommands(tt.args, "--")
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitCommands(%q): got %q; want %q", tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	got := summarize([]float64{2, 4, 4, 4, 5, 5, 7, 9})
+	want := summary{n: 8, mean: 5, stddev: math.Sqrt(32.0 / 7), min: 2, max: 9}
+	if got != want {
+		t.Errorf("got %+v; want %+v", got, want)
+	}
+}
+
+func TestTQuantile975(t *testing.T) {
+	for _, tt := range []struct {
+		df   float64
+		want float64
+	}{
+		{1, 12.706},
+		{10, 2.228},
+		{30, 2.042},
+		{40, 2.021},
+		{60, 2.000},
+		{120, 1.980},
+		{1e9, 1.960},
+	} {
+		if got := tQuantile975(tt.df); math.Abs(got-tt.want) > 0.002 {
+			t.Errorf("tQuantile975(%g): got %.4f; want %.3f", tt.df, got, tt.want)
+		}
+	}
+}
+
+func TestWelchInterval(t *testing.T) {
+	// Equal sizes and variances: df = 2n-2 = 18.
+	a := summary{n: 10, mean: 10, stddev: 1}
+	b := summary{n: 10, mean: 11, stddev: 1}
+	lo, hi := welchInterval(a, b)
+	halfWidth := 2.101 * math.Sqrt(0.2)
+	if math.Abs(lo-(1-halfWidth)) > 1e-9 || math.Abs(hi-(1+halfWidth)) > 1e-9 {
+		t.Errorf("got [%g, %g]; want [%g, %g]", lo, hi, 1-halfWidth, 1+halfWidth)
+	}
+
+	// No variance at all.
+	a.stddev, b.stddev = 0, 0
+	if lo, hi := welchInterval(a, b); lo != 1 || hi != 1 {
+		t.Errorf("with no variance, got [%g, %g]; want [1, 1]", lo, hi)
+	}
+}
@@ -0,0 +1,289 @@
+package procmon
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cespare/misc/internal/cgroup2"
+	"golang.org/x/sys/unix"
+)
+
+// Backend selects how Run measures the process tree.
+type Backend int
+
+const (
+	// BackendAuto uses BackendCgroup if possible and BackendProc
+	// otherwise.
+	BackendAuto Backend = iota
+	// BackendProc samples /proc. It can miss short-lived spikes and
+	// processes that escape the tree between samples.
+	BackendProc
+	// BackendCgroup runs the command in a new cgroup v2 group and reads
+	// the cgroup's exact totals for memory, CPU, and I/O after it exits
+	// (while also sampling /proc for the processes in the group).
+	//
+	// This requires a cgroup v2 group that we can write to (see
+	// Options.CgroupParent) and that has the memory controller enabled
+	// for its children. Any processes left in the group after the
+	// command exits are killed.
+	//
+	// Unlike BackendAuto, BackendCgroup may move the calling process into
+	// another group to enable the memory controller; see
+	// Options.CgroupParent.
+	BackendCgroup
+)
+
+func (b Backend) String() string {
+	switch b {
+	case BackendAuto:
+		return "auto"
+	case BackendProc:
+		return "proc"
+	case BackendCgroup:
+		return "cgroup"
+	default:
+		return "Backend(?)"
+	}
+}
+
+func (b Backend) MarshalText() ([]byte, error) {
+	return []byte(b.String()), nil
+}
+
+// ParseBackend parses the name of a Backend (as given by Backend.String).
+func ParseBackend(s string) (Backend, error) {
+	for _, b := range []Backend{BackendAuto, BackendProc, BackendCgroup} {
+		if s == b.String() {
+			return b, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown backend %q", s)
+}
+
+// A cgroup is a cgroup v2 group created for a single command.
+type cgroup struct {
+	dir  string
+	f    *os.File // the open directory, for SysProcAttr.CgroupFD
+	leaf string   // our own leaf group, if dir is in our own group
+}
+
+type cgroupStats struct {
+	peakMemory int64 // bytes
+	userCPU    time.Duration
+	systemCPU  time.Duration
+	readBytes  int64
+	writeBytes int64
+}
+
+var cgroupSeq atomic.Int64
+
+// createCgroup creates a new group under parent. If parent is empty, it
+// uses the calling process's own group (see ownCgroupParent, which is
+// passed enter).
+func createCgroup(parent string, enter bool) (*cgroup, error) {
+	var leaf string
+	if parent == "" {
+		var err error
+		if parent, err = ownCgroupParent(enter); err != nil {
+			return nil, err
+		}
+		leaf = filepath.Join(parent, ownLeaf)
+	}
+	name := fmt.Sprintf("procmon-%d-%d", os.Getpid(), cgroupSeq.Add(1))
+	dir := filepath.Join(parent, name)
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("cannot create cgroup: %s", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "memory.peak")); err != nil {
+		os.Remove(dir)
+		return nil, fmt.Errorf("cgroup %s has no memory.peak (is the memory controller enabled in %s/cgroup.subtree_control?)", dir, parent)
+	}
+	f, err := os.Open(dir)
+	if err != nil {
+		os.Remove(dir)
+		return nil, err
+	}
+	return &cgroup{dir: dir, f: f, leaf: leaf}, nil
+}
+
+// ownLeaf is the name of the leaf group that ownCgroupParent moves into.
+const ownLeaf = "procmon"
+
+var ownParent struct {
+	once sync.Once
+	dir  string // our group before we moved into the leaf
+	err  error
+
+	enterOnce sync.Once
+	enterErr  error
+}
+
+// ownCgroupParent returns the calling process's own group, to be the
+// parent of the groups that createCgroup makes.
+//
+// Outside the root group, cgroup v2 won't enable a controller for a
+// group's children while the group itself has processes in it. If enter
+// is set, the first call moves this process into a leaf group (procmon)
+// and only then enables the memory controller (see
+// cgroup2.EnableControllers).
+// Otherwise the group is used as is, and createCgroup fails unless the
+// controller is already enabled.
+func ownCgroupParent(enter bool) (string, error) {
+	ownParent.once.Do(func() {
+		ownParent.dir, ownParent.err = cgroup2.OwnDir()
+	})
+	if ownParent.err != nil || !enter {
+		return ownParent.dir, ownParent.err
+	}
+	ownParent.enterOnce.Do(func() {
+		dir := ownParent.dir
+		ownParent.enterErr = cgroup2.EnableControllers(dir, filepath.Join(dir, ownLeaf), []string{"memory"})
+	})
+	return ownParent.dir, ownParent.enterErr
+}
+
+// procs lists the PIDs in the group.
+func (cg *cgroup) procs() ([]int, error) {
+	b, err := os.ReadFile(filepath.Join(cg.dir, "cgroup.procs"))
+	if err != nil {
+		return nil, err
+	}
+	return parseCgroupProcs(b)
+}
+
+func (cg *cgroup) stats() (cgroupStats, error) {
+	var cs cgroupStats
+	b, err := os.ReadFile(filepath.Join(cg.dir, "memory.peak"))
+	if err != nil {
+		return cs, err
+	}
+	if cs.peakMemory, err = strconv.ParseInt(string(bytes.TrimSpace(b)), 10, 64); err != nil {
+		return cs, fmt.Errorf("malformed memory.peak: %s", err)
+	}
+	if cs.userCPU, cs.systemCPU, err = cg.cpu(); err != nil {
+		return cs, err
+	}
+	// io.stat is only present if the io controller is enabled.
+	if b, err := os.ReadFile(filepath.Join(cg.dir, "io.stat")); err == nil {
+		if cs.readBytes, cs.writeBytes, err = parseIOStat(b); err != nil {
+			return cs, err
+		}
+	}
+	return cs, nil
+}
+
+// cpu returns the group's total user and system CPU time.
+func (cg *cgroup) cpu() (user, system time.Duration, err error) {
+	b, err := os.ReadFile(filepath.Join(cg.dir, "cpu.stat"))
+	if err != nil {
+		return 0, 0, err
+	}
+	user, system = parseCPUStat(b)
+	return user, system, nil
+}
+
+// signal sends sig to every process in the group.
+func (cg *cgroup) signal(sig unix.Signal) error {
+	if sig == unix.SIGKILL {
+		err := os.WriteFile(filepath.Join(cg.dir, "cgroup.kill"), []byte("1"), 0)
+		if err == nil || !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		// cgroup.kill is new in Linux 5.14; fall back to killing the
+		// processes one at a time.
+	}
+	pids, err := cg.procs()
+	if err != nil {
+		return err
+	}
+	for _, pid := range pids {
+		unix.Kill(pid, sig)
+	}
+	return nil
+}
+
+// destroy kills any remaining processes and removes the group, along
+// with our leaf group if it is empty.
+func (cg *cgroup) destroy() error {
+	defer cg.f.Close()
+	if err := cg.signal(unix.SIGKILL); err != nil {
+		return err
+	}
+	// Removing the group fails with EBUSY until the killed processes are
+	// gone.
+	var err error
+	for range 100 {
+		if err = unix.Rmdir(cg.dir); err != unix.EBUSY {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		return fmt.Errorf("cannot remove cgroup %s: %s", cg.dir, err)
+	}
+	if cg.leaf != "" {
+		cgroup2.RemoveLeaf(cg.leaf)
+	}
+	return nil
+}
+
+func parseCgroupProcs(b []byte) ([]int, error) {
+	var pids []int
+	for _, f := range bytes.Fields(b) {
+		pid, err := strconv.Atoi(string(f))
+		if err != nil {
+			return nil, fmt.Errorf("malformed cgroup.procs: %s", err)
+		}
+		pids = append(pids, pid)
+	}
+	return pids, nil
+}
+
+// parseCPUStat parses the user and system CPU time from a cpu.stat file.
+func parseCPUStat(b []byte) (user, system time.Duration) {
+	v := parseKeyValues(b, "user_usec", "system_usec")
+	return time.Duration(v[0]) * time.Microsecond, time.Duration(v[1]) * time.Microsecond
+}
+
+// parseIOStat parses an io.stat file and returns the bytes read and
+// written, summed over all devices.
+func parseIOStat(b []byte) (read, write int64, err error) {
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for scanner.Scan() {
+		// 8:0 rbytes=90430464 wbytes=299008000 rios=8950 wios=1252 dbytes=0 dios=0
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		for _, f := range fields[1:] {
+			k, v, ok := strings.Cut(f, "=")
+			if !ok {
+				return 0, 0, fmt.Errorf("malformed io.stat line %q", scanner.Text())
+			}
+			var dst *int64
+			switch k {
+			case "rbytes":
+				dst = &read
+			case "wbytes":
+				dst = &write
+			default:
+				continue
+			}
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return 0, 0, fmt.Errorf("malformed io.stat line %q: %s", scanner.Text(), err)
+			}
+			*dst += n
+		}
+	}
+	return read, write, nil
+}
@@ -0,0 +1,72 @@
+package procmon
+
+import (
+	"context"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestCgroupStats(t *testing.T) {
+	cg := &cgroup{dir: filepath.Join("testdata", "cgroup", "group")}
+	got, err := cg.stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := cgroupStats{
+		peakMemory: 3145728000,
+		userCPU:    4 * time.Second,
+		systemCPU:  1123456 * time.Microsecond,
+		readBytes:  90430464 + 4096,
+		writeBytes: 299008000,
+	}
+	if got != want {
+		t.Errorf("got %+v; want %+v", got, want)
+	}
+	pids, err := cg.procs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{1234, 1240, 1301}; !slices.Equal(pids, want) {
+		t.Errorf("procs: got %v; want %v", pids, want)
+	}
+}
+
+func TestParseIOStatMalformed(t *testing.T) {
+	for _, s := range []string{
+		"8:0 rbytes",
+		"8:0 rbytes=x wbytes=0",
+	} {
+		if _, _, err := parseIOStat([]byte(s)); err == nil {
+			t.Errorf("parseIOStat(%q): got nil error", s)
+		}
+	}
+}
+
+func TestCgroupBackend(t *testing.T) {
+	cg, err := createCgroup("", true)
+	if err != nil {
+		t.Skip("cgroup backend unavailable:", err)
+	}
+	cg.destroy()
+
+	stats, err := Run(context.Background(), helperCommand("escape"), Options{
+		SampleInterval: 10 * time.Millisecond,
+		Backend:        BackendCgroup,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Backend != BackendCgroup {
+		t.Fatalf("got backend %s; want cgroup", stats.Backend)
+	}
+	// Unlike TrackProcessGroup, the cgroup sees the child that escaped
+	// the process group.
+	if stats.PeakProcs != 2 {
+		t.Errorf("got PeakProcs=%d; want 2", stats.PeakProcs)
+	}
+	if stats.PeakMemory == 0 {
+		t.Error("got PeakMemory=0")
+	}
+}
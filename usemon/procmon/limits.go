@@ -0,0 +1,78 @@
+package procmon
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"golang.org/x/sys/unix"
+)
+
+// DefaultGracePeriod is the grace period used if Limits.GracePeriod is zero.
+const DefaultGracePeriod = 5 * time.Second
+
+// Limits are resource budgets for a process tree. Zero values mean no
+// limit.
+//
+// Except for Wall, the limits are checked against each sample, so a
+// tree can exceed them in between samples.
+type Limits struct {
+	RSS   int64 // bytes, summed across the tree
+	CPU   time.Duration
+	Wall  time.Duration
+	Procs int
+
+	// GracePeriod is how long to wait after sending SIGTERM to a tree
+	// that exceeded a limit before sending SIGKILL.
+	GracePeriod time.Duration
+}
+
+// A LimitError is returned by Run if the process tree exceeded one of its
+// limits and was killed.
+type LimitError struct {
+	Limit string // "rss", "cpu", "wall", or "procs"
+	Used  string
+	Max   string
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%s limit exceeded (%s > %s)", e.Limit, e.Used, e.Max)
+}
+
+// checkLimits checks the latest sample against the limits.
+func (s *sampler) checkLimits(smp Sample) *LimitError {
+	l := s.limits
+	switch {
+	case l.Procs > 0 && smp.Procs > l.Procs:
+		return &LimitError{
+			Limit: "procs",
+			Used:  fmt.Sprint(smp.Procs),
+			Max:   fmt.Sprint(l.Procs),
+		}
+	case l.RSS > 0 && smp.RSS > l.RSS:
+		return &LimitError{
+			Limit: "rss",
+			Used:  humanize.Bytes(uint64(smp.RSS)),
+			Max:   humanize.Bytes(uint64(l.RSS)),
+		}
+	case l.CPU > 0 && smp.CPU > l.CPU:
+		return &LimitError{
+			Limit: "cpu",
+			Used:  smp.CPU.String(),
+			Max:   l.CPU.String(),
+		}
+	}
+	return nil
+}
+
+// exceed records that a limit was exceeded and sends SIGTERM to the tree.
+// It returns a channel that fires when the grace period is over.
+func (s *sampler) exceed(e *LimitError) <-chan time.Time {
+	s.exceeded = e
+	s.signal(unix.SIGTERM)
+	grace := s.limits.GracePeriod
+	if grace <= 0 {
+		grace = DefaultGracePeriod
+	}
+	return time.After(grace)
+}
@@ -0,0 +1,80 @@
+package procmon
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLimits(t *testing.T) {
+	for _, tt := range []struct {
+		helper    string
+		limits    Limits
+		wantLimit string
+		// The command should be killed in this window.
+		minElapsed time.Duration
+		maxElapsed time.Duration
+	}{
+		{"sleep", Limits{Wall: 100 * time.Millisecond}, "wall", 100 * time.Millisecond, 400 * time.Millisecond},
+		{"spin", Limits{CPU: 200 * time.Millisecond}, "cpu", 200 * time.Millisecond, 2 * time.Second},
+		{"alloc", Limits{RSS: 100 << 20}, "rss", 0, 2 * time.Second},
+		{"escape", Limits{Procs: 1}, "procs", 0, 400 * time.Millisecond},
+		// SIGTERM is ignored, so it takes SIGKILL after the grace period.
+		{
+			"ignore-term",
+			Limits{Wall: 100 * time.Millisecond, GracePeriod: 300 * time.Millisecond},
+			"wall",
+			400 * time.Millisecond,
+			1 * time.Second,
+		},
+		// The wall limit passes during the grace period of the CPU
+		// limit; it neither replaces the CPU limit nor delays the kill.
+		{
+			"spin-ignore-term",
+			Limits{CPU: 100 * time.Millisecond, Wall: 300 * time.Millisecond, GracePeriod: 400 * time.Millisecond},
+			"cpu",
+			500 * time.Millisecond,
+			1 * time.Second,
+		},
+	} {
+		t.Run(tt.wantLimit+"/"+tt.helper, func(t *testing.T) {
+			stats, err := Run(context.Background(), helperCommand(tt.helper), Options{
+				SampleInterval: 10 * time.Millisecond,
+				Track:          TrackParent,
+				Backend:        BackendProc,
+				Limits:         tt.limits,
+			})
+			var le *LimitError
+			if !errors.As(err, &le) {
+				t.Fatalf("got err=%v; want LimitError", err)
+			}
+			if le.Limit != tt.wantLimit || stats.LimitExceeded != tt.wantLimit {
+				t.Errorf("got limit %q (in stats: %q); want %q", le.Limit, stats.LimitExceeded, tt.wantLimit)
+			}
+			if stats.Elapsed < tt.minElapsed || stats.Elapsed > tt.maxElapsed {
+				t.Errorf("command took %s; want between %s and %s",
+					stats.Elapsed, tt.minElapsed, tt.maxElapsed)
+			}
+		})
+	}
+}
+
+func TestWithinLimits(t *testing.T) {
+	stats, err := Run(context.Background(), helperCommand("sleep"), Options{
+		SampleInterval: 10 * time.Millisecond,
+		Backend:        BackendProc,
+		Limits: Limits{
+			RSS:   1 << 30,
+			CPU:   time.Second,
+			Wall:  5 * time.Second,
+			Procs: 2,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.LimitExceeded != "" {
+		t.Errorf("got LimitExceeded=%q", stats.LimitExceeded)
+	}
+}
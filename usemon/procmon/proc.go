@@ -0,0 +1,233 @@
+package procmon
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"slices"
+	"strconv"
+	"time"
+)
+
+var pageSize = int64(os.Getpagesize())
+
+// procStat holds the fields of /proc/[pid]/stat that we use.
+type procStat struct {
+	state     byte
+	ppid      int
+	pgrp      int
+	threads   int
+	cpu       time.Duration // utime+stime
+	startTime uint64        // clock ticks after boot
+	rss       int64         // bytes
+}
+
+// clockTicks is USER_HZ, the unit of times in /proc/[pid]/stat. It is 100
+// on every Linux platform.
+const clockTicks = 100
+
+// procCounters holds cumulative per-process counters.
+type procCounters struct {
+	cpu         time.Duration
+	readBytes   int64
+	writeBytes  int64
+	volCtxSw    int64
+	nonvolCtxSw int64
+}
+
+func (c *procCounters) add(c1 procCounters) {
+	c.cpu += c1.cpu
+	c.readBytes += c1.readBytes
+	c.writeBytes += c1.writeBytes
+	c.volCtxSw += c1.volCtxSw
+	c.nonvolCtxSw += c1.nonvolCtxSw
+}
+
+// procReader reads files in /proc, reusing a buffer.
+type procReader struct {
+	root string // normally "/proc"
+	buf  []byte
+}
+
+// readAllStats reads /proc/[pid]/stat for every process. Processes that go
+// away while we're looking at them are skipped.
+func (r *procReader) readAllStats() (map[int]procStat, error) {
+	f, err := os.Open(r.root)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	dirs, err := f.Readdirnames(-1)
+	if err != nil {
+		return nil, err
+	}
+	stats := make(map[int]procStat)
+	for _, d := range dirs {
+		if !isDigits(d) {
+			continue
+		}
+		pid, err := strconv.Atoi(d)
+		if err != nil {
+			continue
+		}
+		if err := r.read(pid, "stat"); err != nil {
+			if isGone(err) {
+				continue
+			}
+			return nil, err
+		}
+		st, err := parseStat(r.buf)
+		if err != nil {
+			return nil, err
+		}
+		stats[pid] = st
+	}
+	return stats, nil
+}
+
+// readDetails reads the PSS (in bytes) and cumulative counters of pid. Files
+// that can't be read are treated as zeros: they may be missing on older
+// kernels or hidden from us.
+func (r *procReader) readDetails(pid int) (pss int64, c procCounters) {
+	if r.read(pid, "smaps_rollup") == nil {
+		pss = parseKeyValues(r.buf, "Pss:")[0] * 1024
+	}
+	if r.read(pid, "io") == nil {
+		v := parseKeyValues(r.buf, "read_bytes:", "write_bytes:")
+		c.readBytes, c.writeBytes = v[0], v[1]
+	}
+	if r.read(pid, "status") == nil {
+		v := parseKeyValues(r.buf, "voluntary_ctxt_switches:", "nonvoluntary_ctxt_switches:")
+		c.volCtxSw, c.nonvolCtxSw = v[0], v[1]
+	}
+	return pss, c
+}
+
+func (r *procReader) read(pid int, name string) error {
+	f, err := os.Open(r.root + "/" + strconv.Itoa(pid) + "/" + name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r.buf, err = readAll(f, r.buf)
+	return err
+}
+
+// isGone reports whether err (from reading a /proc file) indicates that the
+// process has gone away or that we may not look at it.
+func isGone(err error) bool {
+	var pathErr *fs.PathError
+	return errors.As(err, &pathErr)
+}
+
+var errProcStatMalformed = errors.New("/proc/[pid]/stat line seems malformed")
+
+// parseStat parses the contents of /proc/[pid]/stat.
+func parseStat(b []byte) (procStat, error) {
+	var st procStat
+	// The second field is the command name in parentheses, which may
+	// itself contain spaces and parentheses; skip to the last ')'.
+	i := bytes.LastIndexByte(b, ')')
+	if i < 0 {
+		return st, errProcStatMalformed
+	}
+	b = b[i+1:]
+	if len(b) > 0 && b[0] == ' ' {
+		b = b[1:]
+	}
+	var start, n int
+	for i, c := range b {
+		if c != ' ' {
+			continue
+		}
+		field := string(b[start:i])
+		var err error
+		switch n {
+		case 0:
+			if len(field) != 1 {
+				return st, errProcStatMalformed
+			}
+			st.state = field[0]
+		case 1:
+			st.ppid, err = strconv.Atoi(field)
+		case 2:
+			st.pgrp, err = strconv.Atoi(field)
+		case 11, 12: // utime, stime
+			var ticks int64
+			ticks, err = strconv.ParseInt(field, 10, 64)
+			st.cpu += time.Duration(ticks) * time.Second / clockTicks
+		case 17:
+			st.threads, err = strconv.Atoi(field)
+		case 19:
+			st.startTime, err = strconv.ParseUint(field, 10, 64)
+		case 21:
+			st.rss, err = strconv.ParseInt(field, 10, 64)
+			st.rss *= pageSize
+			return st, err
+		}
+		if err != nil {
+			return st, err
+		}
+		start = i + 1
+		n++
+	}
+	return st, errProcStatMalformed
+}
+
+// parseKeyValues parses the "Key: value" lines found in files such as
+// /proc/[pid]/status and returns the numeric values for the given keys
+// (including the colon). Missing keys are 0.
+func parseKeyValues(b []byte, keys ...string) []int64 {
+	vals := make([]int64, len(keys))
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for scanner.Scan() {
+		fields := bytes.Fields(scanner.Bytes())
+		if len(fields) < 2 {
+			continue
+		}
+		i := slices.Index(keys, string(fields[0]))
+		if i < 0 {
+			continue
+		}
+		if n, err := strconv.ParseInt(string(fields[1]), 10, 64); err == nil {
+			vals[i] = n
+		}
+	}
+	return vals
+}
+
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// readAll is a helper for reading the contents of a file into a reused slice.
+// It attempts to use a single ReadAt to get the entire contents with a single
+// syscall and falls back to io.ReadAll in other cases.
+func readAll(f *os.File, b []byte) ([]byte, error) {
+	b = b[:cap(b)]
+	if len(b) > 0 {
+		// Attempt to do a single ReadAt for the common case.
+		n, err := f.ReadAt(b, 0)
+		if err == io.EOF {
+			return b[:n], nil
+		}
+		if err != nil {
+			return b[:0], err
+		}
+		// We filled the buffer, so there may be more to read.
+	}
+	// Not enough buffer for ReadAt; fall back to ReadAll.
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return io.ReadAll(f)
+}
@@ -0,0 +1,88 @@
+package procmon
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseStat(t *testing.T) {
+	for _, tt := range []struct {
+		file    string
+		want    procStat // rss in pages
+		wantErr error
+	}{
+		{"simple", procStat{state: 'R', ppid: 6697, pgrp: 6701, threads: 1, startTime: 74821, rss: 284}, nil},
+		{"spaces", procStat{state: 'S', ppid: 1200, pgrp: 1200, threads: 31, cpu: 22440 * time.Millisecond, startTime: 88112, rss: 75210}, nil},
+		{"parens", procStat{state: 'R', ppid: 4300, pgrp: 4300, threads: 1, cpu: 60 * time.Millisecond, startTime: 90000, rss: 1000}, nil},
+		{"onlyparens", procStat{state: 'S', ppid: 4300, pgrp: 4300, threads: 1, cpu: 60 * time.Millisecond, startTime: 90000, rss: 7}, nil},
+		{"kthread", procStat{state: 'I', ppid: 2, pgrp: 0, threads: 1, cpu: 30 * time.Millisecond, startTime: 15, rss: 0}, nil},
+		{"truncated", procStat{}, errProcStatMalformed},
+		{"noparen", procStat{}, errProcStatMalformed},
+	} {
+		b, err := os.ReadFile(filepath.Join("testdata", "stat", tt.file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := parseStat(b)
+		if err != tt.wantErr {
+			t.Errorf("parseStat(%s): got err=%v; want %v", tt.file, err, tt.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		want := tt.want
+		want.rss *= pageSize
+		if got != want {
+			t.Errorf("parseStat(%s): got %+v; want %+v", tt.file, got, want)
+		}
+	}
+}
+
+func TestReadAllStats(t *testing.T) {
+	r := procReader{root: filepath.Join("testdata", "proc")}
+	got, err := r.readAllStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[int]procStat{
+		100: {state: 'S', ppid: 1, pgrp: 100, threads: 1, cpu: 150 * time.Millisecond, startTime: 5000, rss: 250 * pageSize},
+		101: {state: 'R', ppid: 100, pgrp: 100, threads: 4, cpu: 3200 * time.Millisecond, startTime: 5010, rss: 5000 * pageSize},
+		102: {state: 'Z', ppid: 100, pgrp: 100, threads: 1, cpu: 20 * time.Millisecond, startTime: 5020, rss: 0},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v; want %+v", got, want)
+	}
+}
+
+func TestReadDetails(t *testing.T) {
+	r := procReader{root: filepath.Join("testdata", "proc")}
+	for _, tt := range []struct {
+		pid     int
+		wantPSS int64
+		want    procCounters
+	}{
+		{
+			pid:     101,
+			wantPSS: 12345 * 1024,
+			want: procCounters{
+				readBytes:   40960,
+				writeBytes:  8192,
+				volCtxSw:    17,
+				nonvolCtxSw: 230,
+			},
+		},
+		// Missing files are treated as zeros.
+		{pid: 100},
+		{pid: 999},
+	} {
+		pss, c := r.readDetails(tt.pid)
+		if pss != tt.wantPSS || c != tt.want {
+			t.Errorf("readDetails(%d): got (%d, %+v); want (%d, %+v)",
+				tt.pid, pss, c, tt.wantPSS, tt.want)
+		}
+	}
+}
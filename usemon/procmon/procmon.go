@@ -0,0 +1,451 @@
+// Package procmon runs a command and monitors the resource usage of the
+// whole tree of processes it starts.
+//
+// The command is run in its own process group (and, if possible, its own
+// cgroup). While it runs, procmon periodically samples /proc to find the
+// combined resource usage of all the processes in the tree; when it exits,
+// the samples are combined with the rusage reported by wait(2) and the
+// cgroup's totals.
+package procmon
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/dustin/go-humanize"
+	"golang.org/x/sys/unix"
+)
+
+// DefaultSampleInterval is the sample interval used if
+// Options.SampleInterval is zero.
+const DefaultSampleInterval = 500 * time.Millisecond
+
+// Options configure Run.
+type Options struct {
+	// SampleInterval is how often to sample the process tree.
+	SampleInterval time.Duration
+	// OnSample, if non-nil, is called (from another goroutine) with each
+	// sample of the process tree.
+	OnSample func(Sample)
+	// Track selects how processes are assigned to the tree.
+	Track Track
+	// Subreaper, if set, makes the calling process a child subreaper
+	// (see PR_SET_CHILD_SUBREAPER in prctl(2)) while Run runs, so that
+	// orphaned descendants are reparented to it rather than to init and
+	// Run can reap them. Only processes that have been seen as members of
+	// the tree are reaped; the caller's other children are left alone.
+	// It only makes sense with TrackParent.
+	//
+	// The subreaper attribute applies to the whole process. It is
+	// cleared once no Run that set it is running (unless it was already
+	// set). Run reaps orphans that exit while it is running, but any that
+	// outlive the command are left as zombies when they exit.
+	Subreaper bool
+	// Backend selects how the tree is measured.
+	Backend Backend
+	// Limits, if set, are enforced by killing the tree.
+	Limits Limits
+	// CgroupParent is the cgroup v2 directory (such as
+	// /sys/fs/cgroup/user.slice/user-1000.slice/mygroup) in which
+	// BackendCgroup creates a group for the command. If it is empty, the
+	// group is created inside the calling process's own group. With
+	// BackendAuto, that only works if the memory controller is already
+	// enabled there, and otherwise Run falls back to BackendProc. With
+	// BackendCgroup, the first such Run enables the controller if need be
+	// by moving the calling process into a leaf group (procmon) under its
+	// own group, where it stays.
+	CgroupParent string
+}
+
+// A Sample is a single measurement of the process tree.
+type Sample struct {
+	Time    time.Time
+	CPU     time.Duration // cumulative CPU time of the tree
+	RSS     int64         // bytes, summed across the tree
+	PSS     int64         // bytes, summed across the tree
+	Procs   int           // number of processes in the tree
+	Threads int           // number of threads in the tree
+}
+
+// Stats summarize the resources used by a process tree.
+//
+// The peak values come from sampling and so may miss short spikes (except
+// that PeakRSS is at least the largest RSS of any single process, as
+// reported by wait(2)). The counters are the larger of the sampled totals
+// and the rusage totals for the command and the descendants it waited for.
+// With BackendCgroup, CPU and I/O come from the cgroup and cover every
+// process that was in the tree.
+type Stats struct {
+	Backend Backend `json:"backend"` // BackendProc or BackendCgroup
+
+	Elapsed   time.Duration `json:"elapsed_ns"`
+	UserCPU   time.Duration `json:"user_cpu_ns"`
+	SystemCPU time.Duration `json:"system_cpu_ns"`
+
+	PeakRSS     int64 `json:"peak_rss_bytes"`
+	PeakPSS     int64 `json:"peak_pss_bytes"` // proportional set size
+	PeakProcs   int   `json:"peak_procs"`
+	PeakThreads int   `json:"peak_threads"`
+	// PeakMemory is the cgroup's memory.peak (which, unlike RSS,
+	// includes page cache and kernel memory). It is only set by
+	// BackendCgroup.
+	PeakMemory int64 `json:"peak_memory_bytes,omitempty"`
+
+	ReadBytes              int64 `json:"read_bytes"` // storage I/O
+	WriteBytes             int64 `json:"write_bytes"`
+	VoluntaryCtxSwitches   int64 `json:"voluntary_ctx_switches"`
+	InvoluntaryCtxSwitches int64 `json:"involuntary_ctx_switches"`
+
+	// LimitExceeded is the limit (see LimitError) that caused the tree to
+	// be killed, if any.
+	LimitExceeded string `json:"limit_exceeded,omitempty"`
+}
+
+// CPU returns the total (user+system) CPU time.
+func (s *Stats) CPU() time.Duration {
+	return s.UserCPU + s.SystemCPU
+}
+
+func (s *Stats) String() string {
+	str := fmt.Sprintf(
+		"elapsed: %s, cpu: %s (user %s, sys %s), max RSS: %s, max PSS: %s, "+
+			"max procs: %d, max threads: %d, read: %s, write: %s, "+
+			"ctx switches: %d voluntary, %d involuntary",
+		s.Elapsed.Round(100*time.Millisecond),
+		s.CPU().Round(100*time.Millisecond),
+		s.UserCPU.Round(100*time.Millisecond),
+		s.SystemCPU.Round(100*time.Millisecond),
+		humanize.Bytes(uint64(s.PeakRSS)),
+		humanize.Bytes(uint64(s.PeakPSS)),
+		s.PeakProcs,
+		s.PeakThreads,
+		humanize.Bytes(uint64(s.ReadBytes)),
+		humanize.Bytes(uint64(s.WriteBytes)),
+		s.VoluntaryCtxSwitches,
+		s.InvoluntaryCtxSwitches,
+	)
+	if s.Backend == BackendCgroup {
+		str += fmt.Sprintf(", max cgroup memory: %s", humanize.Bytes(uint64(s.PeakMemory)))
+	}
+	if s.LimitExceeded != "" {
+		str += fmt.Sprintf(", exceeded %s limit", s.LimitExceeded)
+	}
+	return str
+}
+
+// Run starts cmd in a new process group and waits for it to exit, sampling
+// the resource usage of its process tree while it runs.
+//
+// If ctx is canceled before cmd exits, the whole process tree is killed.
+// If the tree exceeds one of opts.Limits, it is sent SIGTERM, followed by
+// SIGKILL after the grace period, and Run returns a *LimitError.
+//
+// With BackendAuto, Run falls back to BackendProc if a cgroup can't be
+// created. With BackendCgroup, that is an error.
+//
+// Run returns non-nil Stats whenever cmd was started, even if it returns an
+// error as well. The error is the result of cmd.Wait or, if that succeeded,
+// any error that stopped the sampler.
+func Run(ctx context.Context, cmd *exec.Cmd, opts Options) (*Stats, error) {
+	interval := opts.SampleInterval
+	if interval <= 0 {
+		interval = DefaultSampleInterval
+	}
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = new(syscall.SysProcAttr)
+	}
+	cmd.SysProcAttr.Setpgid = true
+	var adopter int
+	if opts.Subreaper {
+		if err := acquireSubreaper(); err != nil {
+			return nil, fmt.Errorf("cannot become child subreaper: %s", err)
+		}
+		defer releaseSubreaper()
+		adopter = os.Getpid()
+	}
+	var cg *cgroup
+	if opts.Backend != BackendProc {
+		var err error
+		cg, err = createCgroup(opts.CgroupParent, opts.Backend == BackendCgroup)
+		if err != nil && opts.Backend == BackendCgroup {
+			return nil, err
+		}
+	}
+	backend := BackendProc
+	if cg != nil {
+		backend = BackendCgroup
+		// Start the command directly in the cgroup (using clone3's
+		// CLONE_INTO_CGROUP) so that none of its children can be
+		// started outside it.
+		cmd.SysProcAttr.UseCgroupFD = true
+		cmd.SysProcAttr.CgroupFD = int(cg.f.Fd())
+	}
+
+	start := time.Now()
+	if err := cmd.Start(); err != nil {
+		if cg != nil {
+			cg.destroy()
+		}
+		return nil, err
+	}
+	s := &sampler{
+		r:        procReader{root: "/proc"},
+		tracker:  newTracker(opts.Track, cmd.Process.Pid, adopter),
+		cg:       cg,
+		start:    start,
+		limits:   opts.Limits,
+		onSample: opts.OnSample,
+		counters: make(map[procID]procCounters),
+		stats:    &Stats{Backend: backend, PeakProcs: 1},
+	}
+
+	done := make(chan struct{})
+	samplerDone := make(chan error, 1)
+	go func() {
+		samplerDone <- s.run(ctx, interval, done)
+	}()
+	err := cmd.Wait()
+	close(done)
+	sampleErr := <-samplerDone
+	if s.exceeded != nil {
+		s.stats.LimitExceeded = s.exceeded.Limit
+		err = s.exceeded
+	}
+	if err == nil {
+		err = sampleErr
+	}
+	if adopter != 0 {
+		s.reapOrphans()
+	}
+
+	stats := s.stats
+	stats.Elapsed = time.Since(start)
+	rusage := cmd.ProcessState.SysUsage().(*syscall.Rusage)
+	stats.UserCPU = time.Duration(rusage.Utime.Nano())
+	stats.SystemCPU = time.Duration(rusage.Stime.Nano())
+	// Maxrss is only the largest single process, but it may have caught a
+	// spike that we missed in between samples.
+	stats.PeakRSS = max(stats.PeakRSS, rusage.Maxrss*1024)
+
+	var total procCounters
+	for _, c := range s.counters {
+		total.add(c)
+	}
+	stats.ReadBytes = max(total.readBytes, rusage.Inblock*512)
+	stats.WriteBytes = max(total.writeBytes, rusage.Oublock*512)
+	stats.VoluntaryCtxSwitches = max(total.volCtxSw, rusage.Nvcsw)
+	stats.InvoluntaryCtxSwitches = max(total.nonvolCtxSw, rusage.Nivcsw)
+
+	if cg != nil {
+		cs, cgErr := cg.stats()
+		if cgErr == nil {
+			stats.PeakMemory = cs.peakMemory
+			stats.UserCPU = max(stats.UserCPU, cs.userCPU)
+			stats.SystemCPU = max(stats.SystemCPU, cs.systemCPU)
+			stats.ReadBytes = max(stats.ReadBytes, cs.readBytes)
+			stats.WriteBytes = max(stats.WriteBytes, cs.writeBytes)
+		}
+		if destroyErr := cg.destroy(); cgErr == nil {
+			cgErr = destroyErr
+		}
+		if err == nil && cgErr != nil {
+			err = fmt.Errorf("error reading cgroup stats: %s", cgErr)
+		}
+	}
+	return stats, err
+}
+
+type sampler struct {
+	r        procReader
+	tracker  *tracker
+	cg       *cgroup // nil unless using BackendCgroup
+	start    time.Time
+	limits   Limits
+	exceeded *LimitError
+	onSample func(Sample)
+	// counters holds the latest cumulative counters of every process
+	// that has been in the tree.
+	counters map[procID]procCounters
+	stats    *Stats
+	members  []int // as of the latest sample
+}
+
+// run periodically samples the process tree until done is closed.
+func (s *sampler) run(ctx context.Context, interval time.Duration, done <-chan struct{}) error {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	var wallC, graceC <-chan time.Time
+	if s.limits.Wall > 0 {
+		wall := time.NewTimer(s.limits.Wall - time.Since(s.start))
+		defer wall.Stop()
+		wallC = wall.C
+	}
+	for {
+		select {
+		case <-t.C:
+		case <-wallC:
+			if s.exceeded == nil {
+				graceC = s.exceed(&LimitError{
+					Limit: "wall",
+					Used:  time.Since(s.start).Round(time.Millisecond).String(),
+					Max:   s.limits.Wall.String(),
+				})
+			}
+			continue
+		case <-graceC:
+			s.signal(unix.SIGKILL)
+			continue
+		case <-ctx.Done():
+			s.signal(unix.SIGKILL)
+			<-done
+			return nil
+		case <-done:
+			return nil
+		}
+		smp, err := s.sample()
+		if err != nil {
+			return fmt.Errorf("error finding resource usage of process tree: %s", err)
+		}
+		if s.exceeded == nil {
+			if e := s.checkLimits(smp); e != nil {
+				graceC = s.exceed(e)
+			}
+		}
+	}
+}
+
+func (s *sampler) sample() (Sample, error) {
+	procs, err := s.r.readAllStats()
+	if err != nil {
+		return Sample{}, err
+	}
+	if s.cg != nil {
+		// The cgroup is the authority on which processes are in the
+		// tree.
+		pids, err := s.cg.procs()
+		if err != nil {
+			return Sample{}, err
+		}
+		s.members = pids[:0]
+		for _, pid := range pids {
+			if _, ok := procs[pid]; ok {
+				s.members = append(s.members, pid)
+			}
+		}
+	} else {
+		s.members = s.tracker.update(procs)
+	}
+	smp := Sample{Time: time.Now()}
+	for _, pid := range s.members {
+		st := procs[pid]
+		if st.state == 'Z' {
+			s.reap(pid, st)
+			continue
+		}
+		pss, c := s.r.readDetails(pid)
+		c.cpu = st.cpu
+		s.counters[procID{pid, st.startTime}] = c
+		smp.RSS += st.rss
+		smp.PSS += pss
+		smp.Procs++
+		smp.Threads += st.threads
+	}
+	s.stats.PeakRSS = max(s.stats.PeakRSS, smp.RSS)
+	s.stats.PeakPSS = max(s.stats.PeakPSS, smp.PSS)
+	s.stats.PeakProcs = max(s.stats.PeakProcs, smp.Procs)
+	s.stats.PeakThreads = max(s.stats.PeakThreads, smp.Threads)
+	if s.cg != nil {
+		user, system, err := s.cg.cpu()
+		if err != nil {
+			return Sample{}, err
+		}
+		smp.CPU = user + system
+	} else {
+		for _, c := range s.counters {
+			smp.CPU += c.cpu
+		}
+	}
+	if s.onSample != nil {
+		s.onSample(smp)
+	}
+	return smp, nil
+}
+
+// subreaper counts the Runs that need the calling process to be a child
+// subreaper.
+var subreaper struct {
+	mu  sync.Mutex
+	n   int
+	was bool // whether the process was a subreaper to begin with
+}
+
+func acquireSubreaper() error {
+	subreaper.mu.Lock()
+	defer subreaper.mu.Unlock()
+	if subreaper.n == 0 {
+		var was int32
+		if err := unix.Prctl(unix.PR_GET_CHILD_SUBREAPER, uintptr(unsafe.Pointer(&was)), 0, 0, 0); err != nil {
+			return err
+		}
+		subreaper.was = was != 0
+		if !subreaper.was {
+			if err := unix.Prctl(unix.PR_SET_CHILD_SUBREAPER, 1, 0, 0, 0); err != nil {
+				return err
+			}
+		}
+	}
+	subreaper.n++
+	return nil
+}
+
+func releaseSubreaper() {
+	subreaper.mu.Lock()
+	defer subreaper.mu.Unlock()
+	subreaper.n--
+	if subreaper.n == 0 && !subreaper.was {
+		unix.Prctl(unix.PR_SET_CHILD_SUBREAPER, 0, 0, 0, 0)
+	}
+}
+
+// reap waits for pid, a member of the tree, if it is a zombie orphan that
+// was reparented to us. (The command itself is reaped by exec.Cmd.Wait.)
+func (s *sampler) reap(pid int, st procStat) {
+	t := s.tracker
+	if t.adopter == 0 || st.ppid != t.adopter || pid == t.root {
+		return
+	}
+	var ws unix.WaitStatus
+	unix.Wait4(pid, &ws, unix.WNOHANG, nil)
+}
+
+// reapOrphans reaps any orphans in the tree that have exited by the time
+// the command exits.
+func (s *sampler) reapOrphans() {
+	procs, err := s.r.readAllStats()
+	if err != nil {
+		return
+	}
+	for pid, st := range procs {
+		if st.state == 'Z' && s.tracker.known[procID{pid, st.startTime}] {
+			s.reap(pid, st)
+		}
+	}
+}
+
+// signal sends sig to the command's process group and to every other
+// process in the tree.
+func (s *sampler) signal(sig unix.Signal) {
+	if s.cg != nil {
+		s.cg.signal(sig)
+	}
+	unix.Kill(-s.tracker.root, sig)
+	for _, pid := range s.members {
+		unix.Kill(pid, sig)
+	}
+}
@@ -0,0 +1,198 @@
+package procmon
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"runtime"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// The test binary doubles as the commands that the tests monitor.
+// The helper to run is selected by the PROCMON_TEST_HELPER env var.
+
+func TestMain(m *testing.M) {
+	if name := os.Getenv("PROCMON_TEST_HELPER"); name != "" {
+		helpers[name]()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+var helpers = map[string]func(){
+	"sleep":      func() { time.Sleep(500 * time.Millisecond) },
+	"long-sleep": func() { time.Sleep(time.Second) },
+	// Start a child that escapes our process group with setsid and wait
+	// for it.
+	"escape": func() {
+		cmd := helperCommand("sleep")
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+		if err := cmd.Run(); err != nil {
+			log.Fatal(err)
+		}
+	},
+	// Start a child that escapes our process group, starts a grandchild,
+	// and exits, orphaning the grandchild. Wait around for a while
+	// longer (but exit before the grandchild does).
+	"orphan": func() {
+		cmd := helperCommand("fork-and-exit")
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+		if err := cmd.Run(); err != nil {
+			log.Fatal(err)
+		}
+		time.Sleep(300 * time.Millisecond)
+	},
+	"spin": func() {
+		for end := time.Now().Add(3 * time.Second); time.Now().Before(end); {
+		}
+	},
+	"alloc": func() {
+		b := make([]byte, 256<<20)
+		for i := 0; i < len(b); i += 4096 {
+			b[i] = 1
+		}
+		time.Sleep(3 * time.Second)
+		runtime.KeepAlive(b)
+	},
+	"ignore-term": func() {
+		signal.Ignore(syscall.SIGTERM)
+		time.Sleep(3 * time.Second)
+	},
+	"spin-ignore-term": func() {
+		signal.Ignore(syscall.SIGTERM)
+		for end := time.Now().Add(3 * time.Second); time.Now().Before(end); {
+		}
+	},
+	"fork-and-exit": func() {
+		if err := helperCommand("long-sleep").Start(); err != nil {
+			log.Fatal(err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	},
+}
+
+func helperCommand(name string) *exec.Cmd {
+	cmd := exec.Command(os.Args[0])
+	cmd.Env = append(os.Environ(), "PROCMON_TEST_HELPER="+name)
+	return cmd
+}
+
+// runHelper runs the named helper under Run using BackendProc and returns
+// the stats and the process counts of every sample.
+func runHelper(t *testing.T, name string, opts Options) (*Stats, []int) {
+	t.Helper()
+	var mu sync.Mutex
+	var procs []int
+	opts.Backend = BackendProc
+	opts.SampleInterval = 10 * time.Millisecond
+	opts.OnSample = func(s Sample) {
+		mu.Lock()
+		defer mu.Unlock()
+		procs = append(procs, s.Procs)
+	}
+	stats, err := Run(context.Background(), helperCommand(name), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	return stats, procs
+}
+
+func TestStats(t *testing.T) {
+	stats, _ := runHelper(t, "sleep", Options{})
+	if stats.Elapsed < 500*time.Millisecond {
+		t.Errorf("got elapsed %s; want >= 500ms", stats.Elapsed)
+	}
+	if stats.PeakProcs != 1 {
+		t.Errorf("got PeakProcs=%d; want 1", stats.PeakProcs)
+	}
+	if stats.PeakRSS == 0 || stats.PeakPSS == 0 || stats.PeakThreads == 0 {
+		t.Errorf("got PeakRSS=%d, PeakPSS=%d, PeakThreads=%d; want all > 0",
+			stats.PeakRSS, stats.PeakPSS, stats.PeakThreads)
+	}
+	if stats.PeakPSS > stats.PeakRSS {
+		t.Errorf("got PeakPSS=%d > PeakRSS=%d", stats.PeakPSS, stats.PeakRSS)
+	}
+	if stats.VoluntaryCtxSwitches == 0 {
+		t.Error("got 0 voluntary context switches")
+	}
+}
+
+func TestEscapeProcessGroup(t *testing.T) {
+	for _, tt := range []struct {
+		track Track
+		want  int
+	}{
+		{TrackProcessGroup, 1},
+		{TrackParent, 2},
+	} {
+		t.Run(tt.track.String(), func(t *testing.T) {
+			stats, _ := runHelper(t, "escape", Options{Track: tt.track})
+			if stats.PeakProcs != tt.want {
+				t.Errorf("got PeakProcs=%d; want %d", stats.PeakProcs, tt.want)
+			}
+		})
+	}
+}
+
+func TestOrphans(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		opts Options
+	}{
+		{"parent", Options{Track: TrackParent}},
+		{"subreaper", Options{Track: TrackParent, Subreaper: true}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			stats, procs := runHelper(t, "orphan", tt.opts)
+			// The grandchild outlives the command. If it was
+			// reparented to us (because some test made us a
+			// subreaper), reap it so that later tests don't count it.
+			for {
+				if _, err := syscall.Wait4(-1, nil, 0, nil); err != nil {
+					break
+				}
+			}
+			if stats.PeakProcs != 3 {
+				t.Fatalf("got PeakProcs=%d; want 3 (samples: %v)", stats.PeakProcs, procs)
+			}
+			// Right after the intermediate child exits, the command
+			// and the orphaned grandchild should both be counted.
+			last := -1
+			for i, n := range procs {
+				if n == 3 {
+					last = i
+				}
+			}
+			if last+1 >= len(procs) || procs[last+1] != 2 {
+				t.Errorf("after orphaning, want 2 procs (samples: %v)", procs)
+			}
+		})
+	}
+}
+
+func TestCancel(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	stats, err := Run(ctx, helperCommand("escape"), Options{
+		SampleInterval: 10 * time.Millisecond,
+		Track:          TrackParent,
+		Backend:        BackendProc,
+	})
+	if err == nil {
+		t.Fatal("Run succeeded; want error from killed command")
+	}
+	if stats == nil {
+		t.Fatal("got nil stats")
+	}
+	if elapsed := time.Since(start); elapsed > 400*time.Millisecond {
+		t.Errorf("Run took %s after cancellation", elapsed)
+	}
+}
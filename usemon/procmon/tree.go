@@ -0,0 +1,118 @@
+package procmon
+
+import "slices"
+
+// Track selects how Run decides which processes belong to the tree.
+type Track int
+
+const (
+	// TrackProcessGroup counts the processes in the command's process
+	// group. Descendants that call setpgid or setsid (shells running
+	// pipelines, daemons, and so on) are missed.
+	TrackProcessGroup Track = iota
+	// TrackParent builds the tree by following parent PIDs from the
+	// command. Descendants that have been seen once are remembered, so
+	// they're still counted after they are orphaned and reparented.
+	// A descendant that is orphaned before it is first sampled can still
+	// be missed.
+	TrackParent
+)
+
+func (t Track) String() string {
+	switch t {
+	case TrackProcessGroup:
+		return "pgroup"
+	case TrackParent:
+		return "parent"
+	default:
+		return "Track(?)"
+	}
+}
+
+// procID identifies a process. Since PIDs are reused, a (pid, start time)
+// pair is used instead.
+type procID struct {
+	pid       int
+	startTime uint64
+}
+
+// A tracker finds the members of the tree rooted at the command on each
+// sample.
+type tracker struct {
+	mode Track
+	root int
+	// If adopter is nonzero, we're a child subreaper with that PID, and
+	// tree members whose parent is adopter are orphans that we reap.
+	// (Other children of adopter aren't members: they may be unrelated
+	// children of Run's caller.)
+	adopter int
+	// known holds every tree member from the previous sample.
+	known map[procID]bool
+}
+
+func newTracker(mode Track, root, adopter int) *tracker {
+	return &tracker{
+		mode:    mode,
+		root:    root,
+		adopter: adopter,
+		known:   make(map[procID]bool),
+	}
+}
+
+// update returns the PIDs of the tree members among procs, in increasing
+// order.
+func (t *tracker) update(procs map[int]procStat) []int {
+	var members []int
+	switch t.mode {
+	case TrackProcessGroup:
+		for pid, st := range procs {
+			if st.pgrp == t.root {
+				members = append(members, pid)
+			}
+		}
+	case TrackParent:
+		members = t.updateParent(procs)
+	default:
+		panic("bad Track")
+	}
+	slices.Sort(members)
+	return members
+}
+
+func (t *tracker) updateParent(procs map[int]procStat) []int {
+	children := make(map[int][]int)
+	for pid, st := range procs {
+		children[st.ppid] = append(children[st.ppid], pid)
+	}
+	in := make(map[int]bool)
+	var queue []int
+	add := func(pid int) {
+		if !in[pid] {
+			in[pid] = true
+			queue = append(queue, pid)
+		}
+	}
+	if _, ok := procs[t.root]; ok {
+		add(t.root)
+	}
+	for pid, st := range procs {
+		if t.known[procID{pid, st.startTime}] {
+			add(pid)
+		}
+	}
+	for len(queue) > 0 {
+		pid := queue[0]
+		queue = queue[1:]
+		for _, child := range children[pid] {
+			add(child)
+		}
+	}
+
+	clear(t.known)
+	members := make([]int, 0, len(in))
+	for pid := range in {
+		t.known[procID{pid, procs[pid].startTime}] = true
+		members = append(members, pid)
+	}
+	return members
+}
@@ -0,0 +1,161 @@
+package procmon
+
+import (
+	"slices"
+	"testing"
+)
+
+// proc is shorthand for constructing a procStat.
+func proc(ppid, pgrp int, startTime uint64) procStat {
+	return procStat{state: 'S', ppid: ppid, pgrp: pgrp, startTime: startTime}
+}
+
+func TestTracker(t *testing.T) {
+	type step struct {
+		procs map[int]procStat
+		want  []int
+	}
+	for _, tt := range []struct {
+		name    string
+		mode    Track
+		adopter int
+		steps   []step
+	}{
+		{
+			name: "pgroup misses setsid",
+			mode: TrackProcessGroup,
+			steps: []step{
+				{
+					procs: map[int]procStat{
+						1:  proc(0, 1, 1),
+						10: proc(1, 10, 100),
+						11: proc(10, 10, 101),
+						12: proc(10, 12, 102), // setsid
+						13: proc(12, 12, 103),
+						20: proc(1, 20, 200), // unrelated
+					},
+					want: []int{10, 11},
+				},
+			},
+		},
+		{
+			name: "parent follows setsid",
+			mode: TrackParent,
+			steps: []step{
+				{
+					procs: map[int]procStat{
+						1:  proc(0, 1, 1),
+						10: proc(1, 10, 100),
+						11: proc(10, 10, 101),
+						12: proc(10, 12, 102),
+						13: proc(12, 12, 103),
+						20: proc(1, 20, 200),
+					},
+					want: []int{10, 11, 12, 13},
+				},
+			},
+		},
+		{
+			name: "parent remembers orphans",
+			mode: TrackParent,
+			steps: []step{
+				{
+					procs: map[int]procStat{
+						1:  proc(0, 1, 1),
+						10: proc(1, 10, 100),
+						12: proc(10, 12, 102),
+						13: proc(12, 13, 103),
+					},
+					want: []int{10, 12, 13},
+				},
+				{
+					// 12 exited; 13 was reparented to init
+					// and then forked 14.
+					procs: map[int]procStat{
+						1:  proc(0, 1, 1),
+						10: proc(1, 10, 100),
+						13: proc(1, 13, 103),
+						14: proc(13, 13, 104),
+					},
+					want: []int{10, 13, 14},
+				},
+				{
+					// The root exited too.
+					procs: map[int]procStat{
+						1:  proc(0, 1, 1),
+						13: proc(1, 13, 103),
+						14: proc(13, 13, 104),
+					},
+					want: []int{13, 14},
+				},
+				{
+					// Everything exited and PID 13 was
+					// reused by an unrelated process.
+					procs: map[int]procStat{
+						1:  proc(0, 1, 1),
+						13: proc(1, 13, 999),
+					},
+					want: nil,
+				},
+			},
+		},
+		{
+			name: "parent misses unseen orphans",
+			mode: TrackParent,
+			steps: []step{
+				{
+					procs: map[int]procStat{
+						1:  proc(0, 1, 1),
+						10: proc(1, 10, 100),
+						// Forked by a child of 10 that
+						// exited before we looked.
+						13: proc(1, 13, 103),
+					},
+					want: []int{10},
+				},
+			},
+		},
+		{
+			name:    "subreaper keeps seen orphans only",
+			mode:    TrackParent,
+			adopter: 5,
+			steps: []step{
+				{
+					procs: map[int]procStat{
+						1:  proc(0, 1, 1),
+						5:  proc(1, 5, 50),
+						10: proc(5, 10, 100),
+						12: proc(10, 12, 102),
+						13: proc(12, 13, 103),
+						// Started by Run's caller.
+						20: proc(5, 5, 200),
+					},
+					want: []int{10, 12, 13},
+				},
+				{
+					// 12 exited and 13 was reparented to
+					// us, as was an orphan we never saw.
+					procs: map[int]procStat{
+						1:  proc(0, 1, 1),
+						5:  proc(1, 5, 50),
+						10: proc(5, 10, 100),
+						13: proc(5, 13, 103),
+						15: proc(5, 15, 105),
+						20: proc(5, 5, 200),
+					},
+					want: []int{10, 13},
+				},
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := newTracker(tt.mode, 10, tt.adopter)
+			for i, step := range tt.steps {
+				got := tr.update(step.procs)
+				if !slices.Equal(got, step.want) {
+					t.Fatalf("step %d: got %v; want %v", i, got, step.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/cespare/misc/usemon/procmon"
+)
+
+// A sampleRecord is one line of the -samples output.
+type sampleRecord struct {
+	Time     time.Time     `json:"time"`
+	RSS      int64         `json:"rss_bytes"`
+	CPUDelta time.Duration `json:"cpu_delta_ns"` // CPU time used since the previous sample
+	Procs    int           `json:"procs"`
+}
+
+// A sampleWriter writes samples as JSON lines. Its write method is
+// suitable for procmon.Options.OnSample.
+type sampleWriter struct {
+	bw      *bufio.Writer
+	enc     *json.Encoder
+	prevCPU time.Duration
+	err     error
+}
+
+func newSampleWriter(w io.Writer) *sampleWriter {
+	bw := bufio.NewWriter(w)
+	return &sampleWriter{bw: bw, enc: json.NewEncoder(bw)}
+}
+
+func (sw *sampleWriter) write(smp procmon.Sample) {
+	if sw.err != nil {
+		return
+	}
+	rec := sampleRecord{
+		Time:     smp.Time,
+		RSS:      smp.RSS,
+		CPUDelta: smp.CPU - sw.prevCPU,
+		Procs:    smp.Procs,
+	}
+	sw.prevCPU = smp.CPU
+	sw.err = sw.enc.Encode(rec)
+}
+
+// flush flushes the buffered samples and returns the first error
+// encountered while writing them.
+func (sw *sampleWriter) flush() error {
+	if sw.err != nil {
+		return sw.err
+	}
+	return sw.bw.Flush()
+}
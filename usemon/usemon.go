@@ -1,343 +1,194 @@
 package main
 
 import (
-	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"log"
 	"os"
 	"os/exec"
-	"strconv"
-	"sync"
+	"os/signal"
 	"syscall"
 	"time"
 
+	"github.com/cespare/misc/usemon/procmon"
 	"github.com/dustin/go-humanize"
-	"golang.org/x/sys/unix"
 )
 
 func main() {
-	if len(os.Args) < 2 {
-		if err := usemon(); err != nil {
-			log.Fatal(err)
-		}
+	log.SetFlags(0)
+	if len(os.Args) > 1 && os.Args[1] == "bench" {
+		bench(os.Args[2:])
 		return
 	}
-	switch os.Args[1] {
-	case "parent0":
-		if err := parent0(); err != nil {
-			log.Fatal(err)
-		}
-	case "parent1":
-		if err := parent1(); err != nil {
-			log.Fatal(err)
-		}
-	case "mem":
-		if err := useMemory(); err != nil {
-			log.Fatal(err)
-		}
-	case "cpu":
-		if err := useCPU(); err != nil {
-			log.Fatal(err)
-		}
-	default:
-		log.Fatal("bad arg")
-	}
-}
+	var (
+		jsonOutput  = flag.Bool("json", false, "print stats as JSON")
+		samplesFile = flag.String("samples", "", "write each sample to this file as a JSON line")
+	)
+	mf := addMonitorFlags(flag.CommandLine)
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, `usage: %[1]s [flags] -- command [args...]
+       %[1]s bench [flags] -- command [args...] [-- command [args...]]
 
-type processStats struct {
-	elapsed     time.Duration
-	cpuUsage    time.Duration // utime+stime
-	maxRSSBytes int64
-}
+usemon runs a command, monitors the resources used by its process tree, and
+prints a summary to stderr when it exits.
 
-func (ps *processStats) String() string {
-	return fmt.Sprintf(
-		"elapsed: %s, cpu: %s, max RSS: %s",
-		ps.elapsed.Round(100*time.Millisecond),
-		ps.cpuUsage.Round(100*time.Millisecond),
-		humanize.Bytes(uint64(ps.maxRSSBytes)),
-	)
-}
+usemon exits with the status of the command that it runs, or with status %[2]d if
+the command was killed for exceeding a resource limit.
 
-func processTreeRSS(pgid int) (int64, error) {
-	f, err := os.Open("/proc")
-	if err != nil {
-		return 0, err
+Flags:
+`, os.Args[0], exitLimitExceeded)
+		flag.PrintDefaults()
 	}
-	defer f.Close()
-
-	dirs, err := f.Readdirnames(-1)
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	opts, err := mf.options()
 	if err != nil {
-		return 0, err
+		log.Fatal(err)
 	}
-	var total int64
-	var b []byte
-	for _, d := range dirs {
-		if !isDigits(d) {
-			continue
-		}
-		var rss int64
-		var err error
-		rss, b, err = readRSS(d, pgid, b)
+	var sw *sampleWriter
+	if *samplesFile != "" {
+		f, err := os.Create(*samplesFile)
 		if err != nil {
-			switch err {
-			case errWrongProcessGroup:
-			default:
-				log.Fatal(err)
-			}
-			// Many normal errors here -- we don't have permission to look
-			// at root processes, the process has gone away, the
-			// process is not in the process group we care about, etc.
-			continue
+			log.Fatal(err)
 		}
-		total += rss
+		defer f.Close()
+		sw = newSampleWriter(f)
+		opts.OnSample = sw.write
 	}
-	return total, nil
-}
-
-var (
-	errWrongProcessGroup = errors.New("process not in target process group")
-	errProcStatMalformed = errors.New("/proc/[pid]/stat line seems malformed")
-)
 
-func readRSS(pidStr string, pgid int, b []byte) (int64, []byte, error) {
-	f, err := os.Open("/proc/" + pidStr + "/stat")
-	if err != nil {
-		return 0, b, err
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	cmd := exec.Command(flag.Arg(0), flag.Args()[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	stats, runErr := procmon.Run(ctx, cmd, opts)
+	if sw != nil {
+		if err := sw.flush(); err != nil {
+			log.Fatalf("Error writing samples: %s", err)
+		}
 	}
-	defer f.Close()
-
-	b, err = readAll(f, b)
-	if err != nil {
-		return 0, b, err
+	if stats != nil {
+		if err := printStats(os.Stderr, stats, *jsonOutput); err != nil {
+			log.Fatal(err)
+		}
 	}
-
-	rss, err := parseStatRSS(pgid, b)
-	if err != nil {
-		return 0, b, err
+	if runErr != nil {
+		exitWithError(runErr)
 	}
-	return rss, b, nil
 }
 
-func parseStatRSS(pgid int, b []byte) (int64, error) {
-	i := bytes.LastIndexByte(b, ')')
-	if i < 0 {
-		return 0, errProcStatMalformed
-	}
-	b = b[i+1:]
-	if len(b) > 0 && b[0] == ' ' {
-		b = b[1:]
+func printStats(w io.Writer, stats *procmon.Stats, jsonOutput bool) error {
+	if !jsonOutput {
+		_, err := fmt.Fprintln(w, stats)
+		return err
 	}
-	var start, n int
-	for i, c := range b {
-		if c != ' ' {
-			continue
-		}
-		switch n {
-		case 2:
-			id, err := strconv.Atoi(string(b[start:i]))
-			if err != nil {
-				return 0, err
-			}
-			if id != pgid {
-				return 0, errWrongProcessGroup
-			}
-		case 21:
-			rss, err := strconv.ParseInt(string(b[start:i]), 10, 64)
-			if err != nil {
-				return 0, err
-			}
-			return rss * 4096, nil
-		}
-		start = i + 1
-		n++
+	b, err := json.Marshal(stats)
+	if err != nil {
+		return err
 	}
-	return 0, errProcStatMalformed
+	_, err = fmt.Fprintf(w, "%s\n", b)
+	return err
 }
 
-func isDigits(s string) bool {
-	for i := 0; i < len(s); i++ {
-		if s[i] < '0' || s[i] > '9' {
-			return false
-		}
-	}
-	return true
+// monitorFlags are the flags that configure procmon.
+type monitorFlags struct {
+	interval  *time.Duration
+	track     *string
+	subreaper *bool
+	backend   *string
+	cgParent  *string
+	maxRSS    *string
+	maxCPU    *time.Duration
+	maxWall   *time.Duration
+	maxProcs  *int
+	grace     *time.Duration
 }
 
-// readAll is a helper for reading the contents of a file into a reused slice.
-// It attempts to use a single ReadAt to get the entire contents with a single
-// syscall and falls back to ioutil.ReadAll in other cases.
-func readAll(f *os.File, b []byte) ([]byte, error) {
-	b = b[:cap(b)]
-	if len(b) > 0 {
-		// Attempt to do a single ReadAt for the common case.
-		n, err := f.ReadAt(b, 0)
-		if err == nil || err != io.EOF {
-			return b[:n], err
-		}
-	}
-	// Not enough buffer for ReadAt; fall back to ReadAll.
-	if _, err := f.Seek(0, io.SeekStart); err != nil {
-		return nil, err
+func addMonitorFlags(fs *flag.FlagSet) *monitorFlags {
+	return &monitorFlags{
+		interval:  fs.Duration("interval", procmon.DefaultSampleInterval, "how often to sample the process tree"),
+		track:     fs.String("track", "pgroup", `how to find the process tree ("pgroup" or "parent")`),
+		subreaper: fs.Bool("subreaper", false, "become a child subreaper to catch orphans (with -track=parent)"),
+		backend:   fs.String("backend", "auto", `how to measure the tree ("auto", "proc", or "cgroup")`),
+		cgParent:  fs.String("cgroup-parent", "", "delegated cgroup v2 directory in which to create a group (default: our own group)"),
+		maxRSS:    fs.String("max-rss", "", "kill the tree if its total RSS exceeds this size (e.g. 2GB)"),
+		maxCPU:    fs.Duration("max-cpu", 0, "kill the tree if it uses more than this much CPU time"),
+		maxWall:   fs.Duration("max-wall", 0, "kill the tree if it runs for longer than this"),
+		maxProcs:  fs.Int("max-procs", 0, "kill the tree if it has more than this many processes"),
+		grace:     fs.Duration("grace", procmon.DefaultGracePeriod, "time between SIGTERM and SIGKILL when killing the tree"),
 	}
-	return ioutil.ReadAll(f)
 }
 
-func run(cmd string, args ...string) (*processStats, error) {
-	c := exec.Command(cmd, args...)
-	c.SysProcAttr = &unix.SysProcAttr{Setpgid: true}
-	start := time.Now()
-	if err := c.Start(); err != nil {
-		return nil, err
-	}
-	var maxRSS int64
-	rssMonCh := make(chan struct{}, 1)
-	go func() {
-		defer close(rssMonCh)
-		const delay = 500 * time.Millisecond
-		t := time.NewTimer(delay)
-		defer t.Stop()
-		for {
-			select {
-			case <-t.C:
-			case <-rssMonCh:
-				return
-			}
-			rss, err := processTreeRSS(c.Process.Pid)
-			if err != nil {
-				log.Println("Error finding RSS of process tree:", err)
-				return
-			}
-			if rss > maxRSS {
-				maxRSS = rss
-
-			}
-			t.Reset(delay)
+func (f *monitorFlags) options() (procmon.Options, error) {
+	opts := procmon.Options{
+		SampleInterval: *f.interval,
+		Subreaper:      *f.subreaper,
+		CgroupParent:   *f.cgParent,
+		Limits: procmon.Limits{
+			CPU:         *f.maxCPU,
+			Wall:        *f.maxWall,
+			Procs:       *f.maxProcs,
+			GracePeriod: *f.grace,
+		},
+	}
+	if *f.maxRSS != "" {
+		n, err := humanize.ParseBytes(*f.maxRSS)
+		if err != nil {
+			return opts, fmt.Errorf("bad -max-rss: %s", err)
 		}
-	}()
-	err := c.Wait()
-	rssMonCh <- struct{}{}
-	<-rssMonCh
-	rusage := c.ProcessState.SysUsage().(*syscall.Rusage)
-	if rss := rusage.Maxrss * 1024; rss > maxRSS {
-		maxRSS = rss
-	}
-	stats := &processStats{
-		elapsed:     time.Since(start),
-		cpuUsage:    time.Duration(rusage.Stime.Nano() + rusage.Utime.Nano()),
-		maxRSSBytes: maxRSS,
-	}
-	return stats, err
-}
-
-func usemon() error {
-	stats, err := run("./usemon", "parent0")
-	if stats != nil {
-		fmt.Println(stats)
+		opts.Limits.RSS = int64(n)
 	}
+	var err error
+	opts.Backend, err = procmon.ParseBackend(*f.backend)
 	if err != nil {
-		log.Fatal(err)
+		return opts, err
 	}
-	return nil
-}
-
-func parent0() error {
-	var wg sync.WaitGroup
-
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		if err := exec.Command("./usemon", "parent1").Run(); err != nil {
-			log.Fatal(err)
-		}
-	}()
-
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		if err := exec.Command("./usemon", "mem").Run(); err != nil {
-			log.Fatal(err)
-		}
-	}()
-
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		if err := exec.Command("./usemon", "cpu").Run(); err != nil {
-			log.Fatal(err)
-		}
-	}()
-
-	wg.Wait()
-	return nil
-}
-
-func parent1() error {
-	var wg sync.WaitGroup
-
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		if err := exec.Command("./usemon", "mem").Run(); err != nil {
-			log.Fatal(err)
-		}
-	}()
-
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		if err := exec.Command("./usemon", "mem").Run(); err != nil {
-			log.Fatal(err)
-		}
-	}()
-
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		if err := exec.Command("./usemon", "cpu").Run(); err != nil {
-			log.Fatal(err)
-		}
-	}()
-
-	wg.Wait()
-	return nil
+	switch *f.track {
+	case "pgroup":
+		opts.Track = procmon.TrackProcessGroup
+	case "parent":
+		opts.Track = procmon.TrackParent
+	default:
+		return opts, fmt.Errorf("unknown -track %q", *f.track)
+	}
+	return opts, nil
 }
 
-func useMemory() error {
-	s := make([]byte, 1e9)
-	for i := range s {
-		s[i] = byte(i)
-	}
-	time.Sleep(3 * time.Second)
-	for i := 0; i < len(s); i += 1000 {
-		fmt.Fprintln(ioutil.Discard, s[i])
+// exitWithError exits with a status that reflects err, an error from
+// procmon.Run.
+func exitWithError(err error) {
+	var limitErr *procmon.LimitError
+	var exitErr *exec.ExitError
+	switch {
+	case errors.As(err, &limitErr):
+		log.Println("Killed:", limitErr)
+		os.Exit(exitLimitExceeded)
+	case errors.As(err, &exitErr):
+		log.Println("Command failed:", exitErr)
+		os.Exit(exitStatus(exitErr))
+	default:
+		log.Fatal(err)
 	}
-	return nil
 }
 
-func useCPU() error {
-	var wg sync.WaitGroup
-	for i := 0; i < 4; i++ {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
+// exitLimitExceeded is the exit status when the command is killed for
+// exceeding a limit.
+const exitLimitExceeded = 125
 
-			end := time.Now().Add(3 * time.Second)
-			for {
-				var n int
-				for i := 0; i < 1e6; i++ {
-					n += i
-				}
-				fmt.Fprintln(ioutil.Discard, n)
-				if time.Now().After(end) {
-					return
-				}
-			}
-		}()
+// exitStatus gives the conventional shell exit status for a command that
+// exited with err.
+func exitStatus(err *exec.ExitError) int {
+	ws := err.Sys().(syscall.WaitStatus)
+	if ws.Signaled() {
+		return 128 + int(ws.Signal())
 	}
-	wg.Wait()
-	return nil
+	return ws.ExitStatus()
 }
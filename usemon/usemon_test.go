@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cespare/misc/usemon/procmon"
+)
+
+// The test binary doubles as the commands that the tests monitor.
+// The helper to run is selected by the USEMON_TEST_HELPER env var.
+
+func TestMain(m *testing.M) {
+	if name := os.Getenv("USEMON_TEST_HELPER"); name != "" {
+		helpers[name]()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+var helpers = map[string]func(){
+	// parent0 and parent1 make a small tree of processes that use
+	// memory and CPU:
+	//
+	//   parent0
+	//   ├── parent1
+	//   │   ├── mem
+	//   │   ├── mem
+	//   │   └── cpu
+	//   ├── mem
+	//   └── cpu
+	"parent0": func() { runHelpers("parent1", "mem", "cpu") },
+	"parent1": func() { runHelpers("mem", "mem", "cpu") },
+	"mem": func() {
+		b := make([]byte, 64<<20)
+		for i := range b {
+			b[i] = byte(i)
+		}
+		time.Sleep(time.Second)
+		runtime.KeepAlive(b)
+	},
+	"cpu": func() {
+		var wg sync.WaitGroup
+		for range 2 {
+			wg.Go(func() {
+				for end := time.Now().Add(time.Second); time.Now().Before(end); {
+				}
+			})
+		}
+		wg.Wait()
+	},
+}
+
+func helperCommand(name string) *exec.Cmd {
+	cmd := exec.Command(os.Args[0])
+	cmd.Env = append(os.Environ(), "USEMON_TEST_HELPER="+name)
+	return cmd
+}
+
+// runHelpers runs the named helpers concurrently and waits for them.
+func runHelpers(names ...string) {
+	var wg sync.WaitGroup
+	for _, name := range names {
+		wg.Go(func() {
+			if err := helperCommand(name).Run(); err != nil {
+				log.Fatal(err)
+			}
+		})
+	}
+	wg.Wait()
+}
+
+func TestSampleWriter(t *testing.T) {
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	var buf bytes.Buffer
+	sw := newSampleWriter(&buf)
+	for i, smp := range []procmon.Sample{
+		{CPU: 100 * time.Millisecond, RSS: 1000, Procs: 1},
+		{CPU: 250 * time.Millisecond, RSS: 3000, Procs: 3},
+		{CPU: 250 * time.Millisecond, RSS: 2000, Procs: 2},
+	} {
+		smp.Time = start.Add(time.Duration(i) * time.Second)
+		sw.write(smp)
+	}
+	if err := sw.flush(); err != nil {
+		t.Fatal(err)
+	}
+	want := `{"time":"2024-03-01T12:00:00Z","rss_bytes":1000,"cpu_delta_ns":100000000,"procs":1}
+{"time":"2024-03-01T12:00:01Z","rss_bytes":3000,"cpu_delta_ns":150000000,"procs":3}
+{"time":"2024-03-01T12:00:02Z","rss_bytes":2000,"cpu_delta_ns":0,"procs":2}
+`
+	if got := buf.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestSamplesOfTree(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping in short mode")
+	}
+	var buf bytes.Buffer
+	sw := newSampleWriter(&buf)
+	stats, err := procmon.Run(context.Background(), helperCommand("parent0"), procmon.Options{
+		SampleInterval: 50 * time.Millisecond,
+		OnSample:       sw.write,
+		Backend:        procmon.BackendProc,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sw.flush(); err != nil {
+		t.Fatal(err)
+	}
+	recs := readSamples(t, &buf)
+	if len(recs) < 5 {
+		t.Fatalf("got %d samples; want at least 5", len(recs))
+	}
+	var maxProcs int
+	var cpu time.Duration
+	for i, rec := range recs {
+		if i > 0 && !rec.Time.After(recs[i-1].Time) {
+			t.Errorf("sample %d: time %s is not after %s", i, rec.Time, recs[i-1].Time)
+		}
+		if rec.CPUDelta < 0 {
+			t.Errorf("sample %d: negative CPU delta %s", i, rec.CPUDelta)
+		}
+		maxProcs = max(maxProcs, rec.Procs)
+		cpu += rec.CPUDelta
+	}
+	if maxProcs != 7 {
+		t.Errorf("got max procs %d; want 7", maxProcs)
+	}
+	if cpu > stats.CPU() {
+		t.Errorf("sampled CPU %s is more than total CPU %s", cpu, stats.CPU())
+	}
+	if want := int64(64 << 20); stats.PeakRSS < want {
+		t.Errorf("got peak RSS %d; want at least %d", stats.PeakRSS, want)
+	}
+}
+
+func readSamples(t *testing.T, r io.Reader) []sampleRecord {
+	t.Helper()
+	var recs []sampleRecord
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		var rec sampleRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			t.Fatalf("bad sample line %q: %s", scanner.Text(), err)
+		}
+		recs = append(recs, rec)
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return recs
+}
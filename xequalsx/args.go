@@ -0,0 +1,235 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/types"
+	"slices"
+	"strings"
+
+	"golang.org/x/tools/go/analysis"
+)
+
+// A loopVarArg is an argument of an immediately-invoked func literal (as in
+// go func(v T) { ... }(v)) that passes a loop var to a parameter.
+type loopVarArg struct {
+	v     *loopVar
+	arg   *ast.Ident
+	param *ast.Ident // nil if the parameter is unnamed
+}
+
+// inspectFuncLitCall looks for loop vars that are passed as arguments to a
+// func literal that is called immediately. Since Go 1.22 the func literal
+// could simply capture the loop var instead.
+func (in *inspector) inspectFuncLitCall(call *ast.CallExpr) {
+	if in.sharedLoopVars {
+		return // the arguments are copies, which are needed
+	}
+	lit, ok := ast.Unparen(call.Fun).(*ast.FuncLit)
+	if !ok || call.Ellipsis.IsValid() {
+		return
+	}
+	params := flattenParams(lit.Type.Params)
+	if len(params) != len(call.Args) {
+		return // variadic
+	}
+	var found []loopVarArg
+	for i, arg := range call.Args {
+		id, ok := ast.Unparen(arg).(*ast.Ident)
+		if !ok {
+			continue
+		}
+		v := in.findLoopVar(in.pass.TypesInfo.Uses[id])
+		if v == nil {
+			continue
+		}
+		a := loopVarArg{v: v, arg: id, param: params[i]}
+		if in.canDropParam(call, lit, a) {
+			found = append(found, a)
+		}
+	}
+	if len(found) == 0 {
+		return
+	}
+	fix, ok := in.dropParamsFix(call, lit, found)
+	for i, a := range found {
+		d := analysis.Diagnostic{
+			Pos:     a.arg.Pos(),
+			End:     a.arg.End(),
+			Message: "possibly-unnecessary func literal parameter in Go >= 1.22",
+		}
+		if i == 0 && ok {
+			d.SuggestedFixes = []analysis.SuggestedFix{fix}
+		}
+		in.pass.Report(d)
+		in.counts.params++
+	}
+}
+
+// flattenParams lists a function's parameters, one per parameter (rather
+// than one per field). Unnamed parameters are nil.
+func flattenParams(fields *ast.FieldList) []*ast.Ident {
+	var params []*ast.Ident
+	for _, f := range fields.List {
+		if _, ok := f.Type.(*ast.Ellipsis); ok {
+			return nil
+		}
+		if len(f.Names) == 0 {
+			params = append(params, nil)
+		}
+		params = append(params, f.Names...)
+	}
+	return params
+}
+
+func (in *inspector) findLoopVar(obj types.Object) *loopVar {
+	if obj == nil {
+		return nil
+	}
+	for _, s := range in.stack {
+		for _, v := range s.loopVars {
+			if v.obj == obj {
+				return v
+			}
+		}
+	}
+	return nil
+}
+
+// canDropParam reports whether the parameter for a loop var argument can
+// be removed in favor of capturing the loop var without changing the
+// meaning of the code.
+func (in *inspector) canDropParam(call *ast.CallExpr, lit *ast.FuncLit, a loopVarArg) bool {
+	// A loop var declared outside the loop is shared by all iterations
+	// (even in Go >= 1.22), so each call needs its own copy.
+	if !declaredByLoop(a.v) {
+		return false
+	}
+	// The argument is evaluated when the func literal is called; the
+	// captured loop var would reflect later changes.
+	if in.modified(loopBody(a.v), a.v.obj) {
+		return false
+	}
+	if a.param == nil || a.param.Name == "_" {
+		// The loop var must still be used once the argument is gone.
+		for _, id := range in.uses(a.v.obj) {
+			if !slices.ContainsFunc(call.Args, func(arg ast.Expr) bool { return argIdent(arg) == id }) {
+				return true
+			}
+		}
+		return false
+	}
+	obj := in.pass.TypesInfo.Defs[a.param]
+	if obj == nil || !types.Identical(obj.Type(), a.v.obj.Type()) {
+		return false
+	}
+	// Changes to the parameter would instead change the loop var.
+	if in.modified(lit.Body, obj) {
+		return false
+	}
+	// Once the parameter is gone, the loop var's name must refer to the
+	// loop var wherever the parameter is used.
+	if a.param.Name == a.v.name {
+		// The parameter shadows the loop var; see what the name refers
+		// to outside the func literal.
+		outer := in.pass.TypesInfo.Scopes[lit.Type].Parent()
+		_, lookup := outer.LookupParent(a.v.name, lit.Pos())
+		return lookup == a.v.obj
+	}
+	for _, id := range in.uses(obj) {
+		s := in.pass.Pkg.Scope().Innermost(id.Pos())
+		if s == nil {
+			return false
+		}
+		if _, lookup := s.LookupParent(a.v.name, id.Pos()); lookup != a.v.obj {
+			return false
+		}
+	}
+	return true
+}
+
+// dropParamsFix constructs a fix that removes the parameters and
+// arguments of the loop var args found in the call of lit, renaming any
+// parameters whose names differ from their loop vars.
+func (in *inspector) dropParamsFix(call *ast.CallExpr, lit *ast.FuncLit, found []loopVarArg) (analysis.SuggestedFix, bool) {
+	tf := in.pass.Fset.File(call.Pos())
+	src, err := in.pass.ReadFile(tf.Name())
+	if err != nil {
+		return analysis.SuggestedFix{}, false
+	}
+	text := func(n ast.Node) string {
+		return string(src[tf.Offset(n.Pos()):tf.Offset(n.End())])
+	}
+	isDropped := func(id *ast.Ident) bool {
+		for _, a := range found {
+			if a.arg == id {
+				return true
+			}
+		}
+		return false
+	}
+
+	// Rebuild the parameter and argument lists without the dropped
+	// ones.
+	var params, args []string
+	i := 0
+	for _, f := range lit.Type.Params.List {
+		if len(f.Names) == 0 {
+			if !isDropped(argIdent(call.Args[i])) {
+				params = append(params, text(f.Type))
+			}
+			i++
+			continue
+		}
+		var names []string
+		for _, name := range f.Names {
+			if !isDropped(argIdent(call.Args[i])) {
+				names = append(names, name.Name)
+			}
+			i++
+		}
+		if len(names) > 0 {
+			params = append(params, strings.Join(names, ", ")+" "+text(f.Type))
+		}
+	}
+	for _, arg := range call.Args {
+		if !isDropped(argIdent(arg)) {
+			args = append(args, text(arg))
+		}
+	}
+	edits := []analysis.TextEdit{
+		{
+			Pos:     lit.Type.Params.Opening + 1,
+			End:     lit.Type.Params.Closing,
+			NewText: []byte(strings.Join(params, ", ")),
+		},
+		{
+			Pos:     call.Lparen + 1,
+			End:     call.Rparen,
+			NewText: []byte(strings.Join(args, ", ")),
+		},
+	}
+	var names []string
+	for _, a := range found {
+		names = append(names, a.v.name)
+		if a.param == nil || a.param.Name == a.v.name {
+			continue
+		}
+		for _, id := range in.uses(in.pass.TypesInfo.Defs[a.param]) {
+			edits = append(edits, analysis.TextEdit{
+				Pos:     id.Pos(),
+				End:     id.End(),
+				NewText: []byte(a.v.name),
+			})
+		}
+	}
+	return analysis.SuggestedFix{
+		Message:   fmt.Sprintf("Capture %s instead of passing it as an argument", strings.Join(names, ", ")),
+		TextEdits: edits,
+	}, true
+}
+
+func argIdent(arg ast.Expr) *ast.Ident {
+	id, _ := ast.Unparen(arg).(*ast.Ident)
+	return id
+}
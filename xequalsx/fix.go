@@ -0,0 +1,224 @@
+package main
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+	"slices"
+	"strings"
+
+	"golang.org/x/tools/go/analysis"
+)
+
+// fix constructs a fix that removes the assignment assn (of loop var v,
+// declared in scope s) and, if the assignment declares a different name
+// than the loop var's, renames the uses of that name to the loop var.
+//
+// The fix is only offered if it doesn't change the meaning of the code:
+// neither the loop var nor the copy may be modified, and the loop var's
+// name must refer to the loop var everywhere the copy is used.
+//
+// If every pair of a multi-assignment (as in k, v := k, v) is removable,
+// the first pair's fix removes the whole statement and the others get no
+// fix (so that the fixes don't conflict).
+func (in *inspector) fix(s *scope, v *loopVar, assn *assignment) (analysis.SuggestedFix, bool) {
+	if !in.canRemove(v, assn) {
+		return analysis.SuggestedFix{}, false
+	}
+	type pair struct {
+		v    *loopVar
+		assn *assignment
+	}
+	var pairs []pair
+	for _, v := range s.loopVars {
+		for _, a := range v.assns {
+			if a.stmt == assn.stmt && in.reported(v, a) && in.canRemove(v, a) {
+				pairs = append(pairs, pair{v, a})
+			}
+		}
+	}
+	var edits []analysis.TextEdit
+	if len(pairs) == len(assn.stmt.Lhs) {
+		for _, p := range pairs {
+			if p.assn.idx < assn.idx {
+				return analysis.SuggestedFix{}, false
+			}
+		}
+		edits = append(edits, in.deleteStmt(assn.stmt))
+	} else {
+		pairs = []pair{{v, assn}}
+		edits = append(edits, deletePair(assn.stmt.Lhs, assn.idx))
+		edits = append(edits, deletePair(assn.stmt.Rhs, assn.idx))
+		if !in.declaresOthers(assn.stmt, assn.idx) {
+			edits = append(edits, analysis.TextEdit{
+				Pos:     assn.stmt.TokPos,
+				End:     assn.stmt.TokPos + token.Pos(len(token.DEFINE.String())),
+				NewText: []byte("="),
+			})
+		}
+	}
+	var names []string
+	for _, p := range pairs {
+		names = append(names, p.v.name)
+		if p.assn.sameName {
+			continue
+		}
+		for _, id := range in.uses(p.assn.lhsObj) {
+			edits = append(edits, analysis.TextEdit{
+				Pos:     id.Pos(),
+				End:     id.End(),
+				NewText: []byte(p.v.name),
+			})
+		}
+	}
+	msg := "Remove redundant copy of " + strings.Join(names, ", ")
+	return analysis.SuggestedFix{Message: msg, TextEdits: edits}, true
+}
+
+// reported reports whether popScope reports assn (and so offers a fix).
+func (in *inspector) reported(v *loopVar, assn *assignment) bool {
+	if assn.captured {
+		return !in.sharedLoopVars && declaredByLoop(v)
+	}
+	return assn.sameName
+}
+
+func (in *inspector) canRemove(v *loopVar, assn *assignment) bool {
+	if assn.stmt.Tok != token.DEFINE || in.pass.TypesInfo.Defs[assn.lhs] == nil {
+		return false
+	}
+	// A loop var declared outside the loop is shared by all iterations
+	// (even in Go >= 1.22), and the loop's post statement may change it,
+	// which the body check below doesn't see.
+	if !declaredByLoop(v) {
+		return false
+	}
+	body := loopBody(v)
+	if in.modified(body, v.obj) || in.modified(body, assn.lhsObj) {
+		return false
+	}
+	if !assn.sameName {
+		for _, id := range in.uses(assn.lhsObj) {
+			s := in.pass.Pkg.Scope().Innermost(id.Pos())
+			if s == nil {
+				return false
+			}
+			if _, obj := s.LookupParent(v.name, id.Pos()); obj != v.obj {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func loopBody(v *loopVar) *ast.BlockStmt {
+	switch loop := v.loop.(type) {
+	case *ast.ForStmt:
+		return loop.Body
+	case *ast.RangeStmt:
+		return loop.Body
+	}
+	panic("unreachable")
+}
+
+// uses returns the identifiers that refer to obj, in source order.
+func (in *inspector) uses(obj types.Object) []*ast.Ident {
+	var ids []*ast.Ident
+	for id, o := range in.pass.TypesInfo.Uses {
+		if o == obj {
+			ids = append(ids, id)
+		}
+	}
+	slices.SortFunc(ids, func(a, b *ast.Ident) int { return int(a.Pos() - b.Pos()) })
+	return ids
+}
+
+// modified reports whether n contains an assignment to obj or takes its
+// address.
+func (in *inspector) modified(n ast.Node, obj types.Object) bool {
+	is := func(e ast.Expr) bool {
+		id, ok := ast.Unparen(e).(*ast.Ident)
+		return ok && in.pass.TypesInfo.Uses[id] == obj
+	}
+	var found bool
+	ast.Inspect(n, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.AssignStmt:
+			found = found || slices.ContainsFunc(n.Lhs, is)
+		case *ast.IncDecStmt:
+			found = found || is(n.X)
+		case *ast.RangeStmt:
+			if n.Tok == token.ASSIGN {
+				found = found || (n.Key != nil && is(n.Key)) || (n.Value != nil && is(n.Value))
+			}
+		case *ast.UnaryExpr:
+			found = found || (n.Op == token.AND && is(n.X))
+		case *ast.SelectorExpr:
+			// A pointer method call takes the address implicitly.
+			if sel, ok := in.pass.TypesInfo.Selections[n]; ok && sel.Kind() == types.MethodVal && is(n.X) {
+				recv := sel.Obj().Type().(*types.Signature).Recv().Type()
+				_, ptrRecv := recv.(*types.Pointer)
+				_, ptrX := in.pass.TypesInfo.TypeOf(n.X).(*types.Pointer)
+				found = found || (ptrRecv && !ptrX)
+			}
+		}
+		return !found
+	})
+	return found
+}
+
+// declaresOthers reports whether the := statement declares a new variable
+// other than the one in pair i (so that it remains valid once that pair
+// is removed).
+func (in *inspector) declaresOthers(stmt *ast.AssignStmt, i int) bool {
+	for j, lhs := range stmt.Lhs {
+		if j == i {
+			continue
+		}
+		if id, ok := lhs.(*ast.Ident); ok && in.pass.TypesInfo.Defs[id] != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// deletePair deletes the ith of a list of expressions, along with a
+// neighboring comma.
+func deletePair(exprs []ast.Expr, i int) analysis.TextEdit {
+	if i < len(exprs)-1 {
+		return analysis.TextEdit{Pos: exprs[i].Pos(), End: exprs[i+1].Pos()}
+	}
+	return analysis.TextEdit{Pos: exprs[i-1].End(), End: exprs[i].End()}
+}
+
+// deleteStmt deletes stmt. If stmt is alone on its line, the whole line is
+// deleted; otherwise, comments and other code on the line are kept (minus
+// the space that separated them from stmt).
+func (in *inspector) deleteStmt(stmt ast.Stmt) analysis.TextEdit {
+	edit := analysis.TextEdit{Pos: stmt.Pos(), End: stmt.End()}
+	tf := in.pass.Fset.File(stmt.Pos())
+	src, err := in.pass.ReadFile(tf.Name())
+	if err != nil {
+		return edit
+	}
+	isSpace := func(b byte) bool { return b == ' ' || b == '\t' }
+	start, end := tf.Offset(stmt.Pos()), tf.Offset(stmt.End())
+	for start > 0 && isSpace(src[start-1]) {
+		start--
+	}
+	for end < len(src) && isSpace(src[end]) {
+		end++
+	}
+	lineStart := start == 0 || src[start-1] == '\n'
+	lineEnd := end == len(src) || src[end] == '\n'
+	switch {
+	case lineStart && lineEnd:
+		edit.Pos, edit.End = tf.Pos(start), tf.Pos(min(end+1, len(src)))
+	case lineEnd:
+		// Don't leave trailing space after whatever precedes stmt.
+		edit.Pos, edit.End = tf.Pos(start), tf.Pos(end)
+	default:
+		edit.End = tf.Pos(end)
+	}
+	return edit
+}
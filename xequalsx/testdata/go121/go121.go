@@ -0,0 +1,51 @@
+package go121
+
+import "fmt"
+
+func use(...any) {}
+
+// These copies are needed before Go 1.22.
+func copies(xs []int) {
+	for _, x := range xs {
+		x := x
+		go func() { fmt.Println(x) }()
+	}
+	for i := range xs {
+		j := i
+		go func() { use(j) }()
+	}
+	for i := range xs {
+		go func(i int) { use(i) }(i)
+	}
+}
+
+func missing(xs []int, m map[string]int) {
+	for _, x := range xs {
+		go func() { fmt.Println(x) }() // want `loop var x captured by goroutine is shared across iterations before Go 1.22 \(missing copy\?\)`
+	}
+	for i := 0; i < len(xs); i++ {
+		go func() {
+			use(i) // want `loop var i captured by goroutine is shared across iterations before Go 1.22 \(missing copy\?\)`
+			use(i)
+			func() { use(xs[i]) }()
+		}()
+	}
+	for k, v := range m {
+		go func() { use(k, v) }() // want `loop var k captured` `loop var v captured`
+	}
+	for _, x := range xs {
+		go fmt.Println(x) // evaluated right away
+		defer func() { use(x) }()
+	}
+	var i int
+	for i = range xs {
+		go func() { use(i) }() // shared in any Go version
+	}
+}
+
+func suspicious(xs []int) {
+	for _, x := range xs {
+		x := x // want "loop var re-declaration is not captured by func literal \\(mistake\\?\\)"
+		use(x)
+	}
+}
@@ -0,0 +1,13 @@
+//go:build go1.22
+
+package mixed
+
+func g(xs []int) {
+	for _, x := range xs {
+		x := x // want "possibly-unnecessary assignment in Go >= 1.22"
+		go func() { use(x) }()
+	}
+	for _, x := range xs {
+		go func() { use(x) }()
+	}
+}
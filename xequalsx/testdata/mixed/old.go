@@ -0,0 +1,11 @@
+package mixed
+
+func use(...any) {}
+
+// This file uses the module's Go version (1.21), so the copy is needed.
+func f(xs []int) {
+	for _, x := range xs {
+		x := x
+		go func() { use(x) }()
+	}
+}
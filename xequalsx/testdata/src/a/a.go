@@ -0,0 +1,93 @@
+package a
+
+import "fmt"
+
+func use(...any) {}
+
+func sameName(xs []int) {
+	for _, x := range xs {
+		x := x // want "possibly-unnecessary assignment in Go >= 1.22"
+		go func() { fmt.Println(x) }()
+	}
+	for i := 0; i < 3; i++ {
+		// Make a copy for the goroutine.
+		i := i // want "possibly-unnecessary assignment in Go >= 1.22"
+		go func() { fmt.Println(i) }()
+	}
+	for _, x := range xs {
+		x := x // copy for the closure // want "possibly-unnecessary assignment in Go >= 1.22"
+		defer func() { use(x) }()
+	}
+}
+
+func multi(m map[string]int) {
+	for k, v := range m {
+		k, v := k, v // want "possibly-unnecessary assignment in Go >= 1.22" "possibly-unnecessary assignment in Go >= 1.22"
+		go func() { use(k, v) }()
+	}
+	for k := range m {
+		n, k := len(m), k // want "possibly-unnecessary assignment in Go >= 1.22"
+		go func() { use(n, k) }()
+	}
+	for k := range m {
+		k, n := k, len(m) // want "possibly-unnecessary assignment in Go >= 1.22"
+		go func() { use(n, k) }()
+	}
+	for k := range m {
+		var n int
+		n, k := len(m), k // want "possibly-unnecessary assignment in Go >= 1.22"
+		go func() { use(n, k) }()
+	}
+}
+
+func rename(xs []int) {
+	for i, x := range xs {
+		y := x // want "possibly-unnecessary assignment in Go >= 1.22"
+		go func() {
+			fmt.Println(i, y)
+			if y > 0 {
+				use(y)
+			}
+		}()
+	}
+	for i := range xs {
+		j := i // want "possibly-unnecessary assignment in Go >= 1.22"
+		go func(x int) { use(j, x) }(xs[j])
+	}
+}
+
+// No fix is offered for these: removing the copy would change the
+// meaning of the code.
+func noFix(xs []int) {
+	for _, x := range xs {
+		x := x // want "possibly-unnecessary assignment in Go >= 1.22"
+		x++
+		go func() { use(x) }()
+	}
+	for _, x := range xs {
+		y := x // want "possibly-unnecessary assignment in Go >= 1.22"
+		x = 3
+		go func() { use(x, y) }()
+	}
+	for _, x := range xs {
+		y := x // want "possibly-unnecessary assignment in Go >= 1.22"
+		go func() {
+			x := "shadowed"
+			use(x, y)
+		}()
+	}
+	for _, x := range xs {
+		y := x // want "possibly-unnecessary assignment in Go >= 1.22"
+		go func() { use(&y) }()
+	}
+}
+
+// The loop doesn't declare j, so every iteration shares it in any Go
+// version and the copy is needed.
+func assigned(n int) {
+	var j int
+	for j = 0; j < n; j++ {
+		j := j
+		go func() { use(j) }()
+	}
+}
@@ -0,0 +1,76 @@
+package args
+
+import (
+	"fmt"
+	"sync"
+)
+
+func use(...any) {}
+
+func f(xs []int, m map[string]int) {
+	var wg sync.WaitGroup
+	for _, x := range xs {
+		wg.Add(1)
+		go func(x int) {
+			defer wg.Done()
+			fmt.Println(x)
+		}(x) // want "possibly-unnecessary func literal parameter in Go >= 1.22"
+	}
+	for i := 0; i < 3; i++ {
+		defer func(n int) { use(n) }(i) // want "possibly-unnecessary func literal parameter in Go >= 1.22"
+	}
+	for k, v := range m {
+		go func(k string, v int) { use(k, v) }(k, v) // want "possibly-unnecessary func literal parameter in Go >= 1.22" "possibly-unnecessary func literal parameter in Go >= 1.22"
+	}
+	for k, v := range m {
+		go func(k, name string, v int) { use(k, name, v) }(k, "name", v) // want "possibly-unnecessary func literal parameter in Go >= 1.22" "possibly-unnecessary func literal parameter in Go >= 1.22"
+	}
+	for i := range xs {
+		func(_ int, x int) { use(x) }(i, xs[i]) // want "possibly-unnecessary func literal parameter in Go >= 1.22"
+	}
+	for i := range xs {
+		go func(int) {}(i) // want "possibly-unnecessary func literal parameter in Go >= 1.22"
+		use(i)
+	}
+}
+
+// The parameters can't be removed here without changing the meaning of
+// the code.
+func noFix(xs []int, m map[string]int) {
+	for i := 0; i < 3; i++ {
+		go func(i int) { use(i) }(i)
+		i++
+	}
+	for _, x := range xs {
+		go func(x int) {
+			x++
+			use(x)
+		}(x)
+	}
+	for _, x := range xs {
+		go func(x any) { use(x) }(x)
+	}
+	for _, x := range xs {
+		go func(y int) {
+			x := "shadowed"
+			use(x, y)
+		}(x)
+	}
+	for _, x := range xs {
+		go func(xs ...int) { use(xs) }(x)
+	}
+	for _, x := range xs {
+		go func(y int) { use(&y) }(x)
+	}
+	for i := range 3 {
+		j := i
+		go func(j int) { use(j) }(j)
+	}
+	for i := range xs {
+		go func(_ int) {}(i)
+	}
+	var i int
+	for i = 0; i < 3; i++ {
+		go func(i int) { use(i) }(i)
+	}
+}
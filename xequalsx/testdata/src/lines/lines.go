@@ -0,0 +1,21 @@
+package lines
+
+func use(...any) {}
+
+func f(xs []int) {
+	for _, x := range xs {
+		// Copy x for the goroutine.
+		x := x
+		go func() { use(x) }()
+	}
+	for i, x := range xs {
+		y := x // copy
+		go func() {
+			use(i, y) // use the copy
+		}()
+	}
+	for k, v := range xs {
+		/* copies */ k, v := k, v
+		go func() { use(k, v) }()
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"go/ast"
+	"go/version"
+
+	"golang.org/x/tools/go/analysis"
+)
+
+// perIterationVersion is the first Go version in which each iteration of
+// a loop has its own copy of the loop vars.
+const perIterationVersion = "go1.22"
+
+// hasSharedLoopVars reports whether file's Go version (as set by go.mod
+// and any //go:build constraint) predates per-iteration loop vars. If the
+// version is unknown, it assumes not.
+func hasSharedLoopVars(pass *analysis.Pass, file *ast.File) bool {
+	v, ok := pass.TypesInfo.FileVersions[file]
+	if !ok {
+		v = pass.Pkg.GoVersion()
+	}
+	return v != "" && version.Compare(v, perIterationVersion) < 0
+}
+
+// inspectGoStmt looks for loop vars that are captured by the func literal
+// of a go statement in a file with shared loop vars. The goroutine may see
+// the values of later iterations.
+func (in *inspector) inspectGoStmt(stmt *ast.GoStmt) {
+	if !in.sharedLoopVars {
+		return
+	}
+	lit, ok := ast.Unparen(stmt.Call.Fun).(*ast.FuncLit)
+	if !ok {
+		return
+	}
+	seen := make(map[*loopVar]bool)
+	ast.Inspect(lit.Body, func(n ast.Node) bool {
+		id, ok := n.(*ast.Ident)
+		if !ok {
+			return true
+		}
+		v := in.findLoopVar(in.pass.TypesInfo.Uses[id])
+		if v == nil || seen[v] || !declaredByLoop(v) {
+			return true
+		}
+		seen[v] = true
+		in.pass.ReportRangef(id, "loop var %s captured by goroutine is shared across iterations before Go 1.22 (missing copy?)", v.name)
+		in.counts.missing++
+		return true
+	})
+}
+
+// declaredByLoop reports whether v is declared by its loop (rather than
+// being a variable declared elsewhere that the loop assigns to). Only
+// those loop vars became per-iteration in Go 1.22.
+func declaredByLoop(v *loopVar) bool {
+	return v.loop.Pos() <= v.obj.Pos() && v.obj.Pos() < loopBody(v).Pos()
+}
@@ -1,8 +1,12 @@
 package main
 
 import (
+	"fmt"
 	"go/ast"
 	"go/types"
+	"io"
+	"os"
+	"sync"
 
 	"golang.org/x/tools/go/analysis"
 	"golang.org/x/tools/go/analysis/singlechecker"
@@ -10,13 +14,31 @@ import (
 
 var Analyzer = &analysis.Analyzer{
 	Name: "xequalsx",
-	Doc:  "Find 'x := x' declarations unneeded in Go >= 1.22",
+	Doc:  "Find 'x := x' declarations unneeded in Go >= 1.22 (or missing in older Go)",
 	Run:  run,
 }
 
+var summary bool
+
+func init() {
+	Analyzer.Flags.BoolVar(&summary, "summary", false, "Print the number of findings of each kind per package")
+}
+
 type inspector struct {
 	pass  *analysis.Pass
 	stack []*scope
+	// sharedLoopVars is set while inspecting a file whose Go version
+	// predates per-iteration loop vars.
+	sharedLoopVars bool
+	counts         counts
+}
+
+// counts tallies the diagnostics reported for a package.
+type counts struct {
+	copies     int // unneeded copies of loop vars
+	params     int // unneeded func literal parameters
+	missing    int // loop vars captured by goroutines without a copy
+	suspicious int // copies that aren't captured
 }
 
 type scope struct {
@@ -27,12 +49,16 @@ type scope struct {
 type loopVar struct {
 	name  string
 	obj   types.Object
+	loop  ast.Stmt // the *ast.ForStmt or *ast.RangeStmt
 	assns []*assignment
 }
 
 type assignment struct {
+	stmt      *ast.AssignStmt
+	idx       int // index of the pair in stmt
 	lhs       *ast.Ident
 	lhsObj    types.Object
+	rhs       *ast.Ident
 	funcDepth int
 	sameName  bool
 	captured  bool
@@ -41,14 +67,34 @@ type assignment struct {
 func run(pass *analysis.Pass) (any, error) {
 	in := &inspector{pass: pass}
 	for _, f := range pass.Files {
+		in.sharedLoopVars = hasSharedLoopVars(pass, f)
 		ast.Inspect(f, func(n ast.Node) bool {
 			in.inspect(n)
 			return true
 		})
 	}
+	if summary {
+		in.printSummary()
+	}
 	return nil, nil
 }
 
+var (
+	summaryMu  sync.Mutex
+	summaryOut io.Writer = os.Stdout // for tests
+)
+
+func (in *inspector) printSummary() {
+	c := in.counts
+	if c == (counts{}) {
+		return
+	}
+	summaryMu.Lock()
+	defer summaryMu.Unlock()
+	fmt.Fprintf(summaryOut, "%s: %d unneeded copies, %d unneeded params, %d missing copies, %d suspicious copies\n",
+		in.pass.Pkg.Path(), c.copies, c.params, c.missing, c.suspicious)
+}
+
 // The basic idea as we walk the AST:
 //
 // * When we find a for loop, note the loop vars.
@@ -62,6 +108,14 @@ func run(pass *analysis.Pass) (any, error) {
 //   from the current funcDepth, then that assignment's LHS is captured by a
 //   func literal.
 // * Print out warnings for all captured assignments.
+//
+// Separately, when we find a call of a func literal, check whether any of
+// the arguments are loop vars in an enclosing scope that the func literal
+// could capture instead.
+//
+// All of that only applies to files with per-iteration loop vars (Go >=
+// 1.22). In older files, the copies are needed, so instead we look for go
+// statements whose func literals capture loop vars without a copy.
 
 func (in *inspector) inspect(n ast.Node) {
 	if n == nil {
@@ -76,16 +130,16 @@ func (in *inspector) inspect(n ast.Node) {
 
 	switch n := n.(type) {
 	case *ast.RangeStmt:
-		in.addVar(n.Key)
-		in.addVar(n.Value)
+		in.addVar(n, n.Key)
+		in.addVar(n, n.Value)
 	case *ast.ForStmt:
 		switch post := n.Post.(type) {
 		case *ast.AssignStmt:
 			for _, lhs := range post.Lhs {
-				in.addVar(lhs)
+				in.addVar(n, lhs)
 			}
 		case *ast.IncDecStmt:
-			in.addVar(post.X)
+			in.addVar(n, post.X)
 		}
 	case *ast.AssignStmt:
 		if len(n.Lhs) != len(n.Rhs) {
@@ -95,15 +149,19 @@ func (in *inspector) inspect(n ast.Node) {
 		for i, lhs := range n.Lhs {
 			lhsIdent, ok := lhs.(*ast.Ident)
 			if !ok {
-				break
+				continue
 			}
 			rhs := n.Rhs[i]
 			rhsIdent, ok := rhs.(*ast.Ident)
 			if !ok {
-				break
+				continue
 			}
-			in.inspectIdentAssign(lhsIdent, rhsIdent)
+			in.inspectIdentAssign(n, i, lhsIdent, rhsIdent)
 		}
+	case *ast.CallExpr:
+		in.inspectFuncLitCall(n)
+	case *ast.GoStmt:
+		in.inspectGoStmt(n)
 	case *ast.FuncLit:
 		in.curScope().funcDepth++
 	case *ast.Ident:
@@ -115,7 +173,7 @@ func (in *inspector) curScope() *scope {
 	return in.stack[len(in.stack)-1]
 }
 
-func (in *inspector) addVar(expr ast.Expr) {
+func (in *inspector) addVar(loop ast.Stmt, expr ast.Expr) {
 	ident, ok := expr.(*ast.Ident)
 	if !ok {
 		return
@@ -125,19 +183,23 @@ func (in *inspector) addVar(expr ast.Expr) {
 		s.loopVars = append(s.loopVars, &loopVar{
 			name: ident.Name,
 			obj:  obj,
+			loop: loop,
 		})
 	}
 }
 
-func (in *inspector) inspectIdentAssign(lhs, rhs *ast.Ident) {
+func (in *inspector) inspectIdentAssign(stmt *ast.AssignStmt, idx int, lhs, rhs *ast.Ident) {
 	if rhsObj := in.pass.TypesInfo.Uses[rhs]; rhsObj != nil {
 		for _, s := range in.stack {
 			for _, v := range s.loopVars {
 				if rhsObj == v.obj {
 					if lhsObj := in.pass.TypesInfo.ObjectOf(lhs); lhsObj != nil {
 						v.assns = append(v.assns, &assignment{
+							stmt:      stmt,
+							idx:       idx,
 							lhs:       lhs,
 							lhsObj:    lhsObj,
+							rhs:       rhs,
 							sameName:  lhs.Name == rhs.Name,
 							funcDepth: s.funcDepth,
 						})
@@ -149,6 +211,7 @@ func (in *inspector) inspectIdentAssign(lhs, rhs *ast.Ident) {
 	}
 	if lhs.Name == rhs.Name {
 		in.pass.ReportRangef(lhs, "same-name assignment does not reference loop var (mistake?)")
+		in.counts.suspicious++
 	}
 }
 
@@ -176,11 +239,28 @@ func (in *inspector) popScope() {
 
 	for _, v := range s.loopVars {
 		for _, assn := range v.assns {
-			if assn.captured {
-				in.pass.ReportRangef(assn.lhs, "possibly-unnecessary assignment in Go >= 1.22")
-			} else if assn.sameName {
-				in.pass.ReportRangef(assn.lhs, "loop var re-declaration is not captured by func literal (mistake?)")
+			var msg string
+			switch {
+			case assn.captured && (in.sharedLoopVars || !declaredByLoop(v)):
+				continue // the copy is needed
+			case assn.captured:
+				msg = "possibly-unnecessary assignment in Go >= 1.22"
+				in.counts.copies++
+			case assn.sameName:
+				msg = "loop var re-declaration is not captured by func literal (mistake?)"
+				in.counts.suspicious++
+			default:
+				continue
+			}
+			d := analysis.Diagnostic{
+				Pos:     assn.lhs.Pos(),
+				End:     assn.lhs.End(),
+				Message: msg,
+			}
+			if fix, ok := in.fix(s, v, assn); ok {
+				d.SuggestedFixes = []analysis.SuggestedFix{fix}
 			}
+			in.pass.Report(d)
 		}
 	}
 }
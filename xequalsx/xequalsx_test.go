@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"golang.org/x/tools/go/analysis"
+	"golang.org/x/tools/go/analysis/analysistest"
+)
+
+func TestAnalyzer(t *testing.T) {
+	analysistest.RunWithSuggestedFixes(t, analysistest.TestData(), Analyzer, "a", "args")
+}
+
+func TestOldGoVersion(t *testing.T) {
+	analysistest.Run(t, filepath.Join(analysistest.TestData(), "go121"), Analyzer, "./...")
+}
+
+func TestMixedGoVersions(t *testing.T) {
+	analysistest.RunWithSuggestedFixes(t, filepath.Join(analysistest.TestData(), "mixed"), Analyzer, "./...")
+}
+
+func TestSummary(t *testing.T) {
+	if err := Analyzer.Flags.Set("summary", "true"); err != nil {
+		t.Fatal(err)
+	}
+	defer Analyzer.Flags.Set("summary", "false")
+	var buf bytes.Buffer
+	summaryOut = &buf
+	defer func() { summaryOut = os.Stdout }()
+
+	analysistest.Run(t, filepath.Join(analysistest.TestData(), "go121"), Analyzer, "./...")
+	want := "example.com/go121: 0 unneeded copies, 0 unneeded params, 4 missing copies, 1 suspicious copies\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got summary %q; want %q", got, want)
+	}
+}
+
+// TestDeleteLines checks that removing a copy that is alone on its line
+// removes the line. (The fixes in TestAnalyzer can't do that because the
+// "want" comments are on the same lines.)
+func TestDeleteLines(t *testing.T) {
+	results := analysistest.Run(ignoreErrors{}, analysistest.TestData(), Analyzer, "lines")
+	var edits []analysis.TextEdit
+	for _, d := range results[0].Diagnostics {
+		for _, fix := range d.SuggestedFixes {
+			edits = append(edits, fix.TextEdits...)
+		}
+	}
+	if len(edits) == 0 {
+		t.Fatal("no fixes")
+	}
+	filename := filepath.Join(analysistest.TestData(), "src", "lines", "lines.go")
+	src, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := os.ReadFile(filename + ".golden")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Apply the edits from back to front.
+	slices.SortFunc(edits, func(a, b analysis.TextEdit) int { return int(b.Pos - a.Pos) })
+	tf := results[0].Pass.Fset.File(edits[0].Pos)
+	got := src
+	for _, e := range edits {
+		start, end := tf.Offset(e.Pos), tf.Offset(e.End)
+		got = slices.Concat(got[:start], e.NewText, got[end:])
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+type ignoreErrors struct{}
+
+func (ignoreErrors) Errorf(string, ...any) {}